@@ -0,0 +1,144 @@
+// Package aliases reads the friendly names, owners and groups users give the
+// devices on their network.
+package aliases
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/DRuggeri/netgear_exporter/filters"
+)
+
+type Alias struct {
+	Alias string
+	Owner string
+	Group string
+}
+
+// Aliases is the contents of an alias file: CSV lines of MAC address, alias, owner
+// and group. Owner and group may be left out, lines starting with # are ignored.
+//
+//	# mac,alias,owner,group
+//	AA:BB:CC:DD:EE:FF,Living room TV,,media
+//	11:22:33:44:55:66,Tablet,Alice,kids
+type Aliases struct {
+	file string
+
+	mutex   sync.RWMutex
+	aliases map[string]Alias
+	groups  []string
+}
+
+// New reads the alias file. Without a file, no device has an alias.
+func New(file string) (*Aliases, error) {
+	a := &Aliases{file: file, aliases: make(map[string]Alias)}
+	if file == "" {
+		return a, nil
+	}
+
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the alias file again. The previous aliases stay in use when it fails.
+func (a *Aliases) Reload() error {
+	f, err := os.Open(a.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	aliases, err := parse(f)
+	if err != nil {
+		return fmt.Errorf("alias file %s: %s", a.file, err)
+	}
+
+	groupSet := make(map[string]bool)
+	for _, alias := range aliases {
+		if alias.Group != "" {
+			groupSet[alias.Group] = true
+		}
+	}
+	groups := make([]string, 0, len(groupSet))
+	for group := range groupSet {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	a.mutex.Lock()
+	a.aliases = aliases
+	a.groups = groups
+	a.mutex.Unlock()
+	return nil
+}
+
+// Lookup returns what the user named the device with the MAC address
+func (a *Aliases) Lookup(mac string) (Alias, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	alias, found := a.aliases[filters.NormalizeMAC(mac)]
+	return alias, found
+}
+
+// MACs are the MAC addresses in the alias file, sorted
+func (a *Aliases) MACs() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	macs := make([]string, 0, len(a.aliases))
+	for mac := range a.aliases {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+// Groups are the groups in the alias file, sorted by name
+func (a *Aliases) Groups() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.groups
+}
+
+func parse(r io.Reader) (map[string]Alias, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	aliases := make(map[string]Alias)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return aliases, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for len(record) < 4 {
+			record = append(record, "")
+		}
+		if len(record) > 4 {
+			return nil, errors.New(fmt.Sprintf("unexpected line `%s`", strings.Join(record, ",")))
+		}
+
+		mac := filters.NormalizeMAC(record[0])
+		if mac == "" {
+			continue
+		}
+		aliases[mac] = Alias{
+			Alias: strings.TrimSpace(record[1]),
+			Owner: strings.TrimSpace(record[2]),
+			Group: strings.TrimSpace(record[3]),
+		}
+	}
+}
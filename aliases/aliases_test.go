@@ -0,0 +1,60 @@
+package aliases
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAliases(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "aliases.csv")
+	content := "# mac,alias,owner,group\n" +
+		"aa-bb-cc-dd-ee-ff, Living room TV,,media\n" +
+		"11:22:33:44:55:66,Tablet,Alice,kids\n" +
+		"22:33:44:55:66:77,Printer\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias, found := a.Lookup("AA:BB:CC:DD:EE:FF")
+	if !found || alias != (Alias{Alias: "Living room TV", Group: "media"}) {
+		t.Errorf("got %+v (%v) for the TV", alias, found)
+	}
+	alias, found = a.Lookup("22:33:44:55:66:77")
+	if !found || alias != (Alias{Alias: "Printer"}) {
+		t.Errorf("got %+v (%v) for the printer", alias, found)
+	}
+	if _, found := a.Lookup("00:00:00:00:00:01"); found {
+		t.Error("found an alias for an unknown device")
+	}
+	if groups := a.Groups(); !reflect.DeepEqual(groups, []string{"kids", "media"}) {
+		t.Errorf("got groups %v", groups)
+	}
+
+	/* A broken file keeps the previous aliases */
+	if err := os.WriteFile(file, []byte("aa:bb:cc:dd:ee:ff,a,b,c,d\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reload(); err == nil {
+		t.Error("expected an error for a line with too many fields")
+	}
+	if _, found := a.Lookup("11:22:33:44:55:66"); !found {
+		t.Error("lost the aliases after a failed reload")
+	}
+}
+
+func TestNoFile(t *testing.T) {
+	a, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := a.Lookup("AA:BB:CC:DD:EE:FF"); found {
+		t.Error("found an alias without an alias file")
+	}
+}
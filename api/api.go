@@ -0,0 +1,364 @@
+// Package api serves the data the collectors parsed on the last scrape as JSON, for
+// scripts and dashboards that would rather not parse the metrics. Data that is missing
+// or too old is read from the router on request.
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/DRuggeri/netgear_exporter/oui"
+)
+
+type Client struct {
+	MAC            string `json:"mac"`
+	IP             string `json:"ip"`
+	Name           string `json:"name"`
+	ConnectionType string `json:"connection_type"`
+	Vendor         string `json:"vendor,omitempty"`
+	Alias          string `json:"alias,omitempty"`
+	Owner          string `json:"owner,omitempty"`
+	Group          string `json:"group,omitempty"`
+
+	WirelessLinkSpeedMbps         *float64 `json:"wireless_link_speed_mbps,omitempty"`
+	WirelessSignalStrengthPercent *float64 `json:"wireless_signal_strength_percent,omitempty"`
+
+	/* Only read by the detailed Client collector */
+	SSID         string `json:"ssid,omitempty"`
+	APMAC        string `json:"ap_mac,omitempty"`
+	DeviceType   string `json:"device_type,omitempty"`
+	AllowOrBlock string `json:"allow_or_block,omitempty"`
+}
+
+type ClientsResponse struct {
+	Time    time.Time `json:"time"`
+	Clients []Client  `json:"clients"`
+}
+
+type System struct {
+	CPUUtilizationPercent    *float64 `json:"cpu_utilization_percent,omitempty"`
+	PhysicalMemoryMB         *float64 `json:"physical_memory_mb,omitempty"`
+	MemoryUtilizationPercent *float64 `json:"memory_utilization_percent,omitempty"`
+	PhysicalFlashMB          *float64 `json:"physical_flash_mb,omitempty"`
+	AvailableFlashMB         *float64 `json:"available_flash_mb,omitempty"`
+}
+
+type SystemResponse struct {
+	Time   time.Time `json:"time"`
+	System System    `json:"system"`
+}
+
+type TrafficPeriod struct {
+	Period               string   `json:"period"`
+	DownloadBytes        *float64 `json:"download_bytes,omitempty"`
+	UploadBytes          *float64 `json:"upload_bytes,omitempty"`
+	DownloadAverageBytes *float64 `json:"download_average_bytes,omitempty"`
+	UploadAverageBytes   *float64 `json:"upload_average_bytes,omitempty"`
+	ConnectionSeconds    *float64 `json:"connection_seconds,omitempty"`
+}
+
+type TrafficResponse struct {
+	Time    time.Time       `json:"time"`
+	Traffic []TrafficPeriod `json:"traffic"`
+}
+
+/* The periods the router keeps traffic statistics for, in the order they are returned */
+var trafficPeriods = [...]struct {
+	prefix string
+	label  string
+}{
+	{"Today", "today"},
+	{"Yesterday", "yesterday"},
+	{"Week", "week"},
+	{"Month", "month"},
+	{"LastMonth", "last_month"},
+}
+
+// Clients serves the attached clients of the last scrape. They can be filtered with the
+// mac, connection_type and group query parameters (comma separated), ip, and name, a
+// regular expression matching the name the router reports or the alias.
+type Clients struct {
+	vendors *oui.DB
+	aliases *aliases.Aliases
+	refresh func()
+	maxAge  time.Duration
+
+	mutex   sync.RWMutex
+	time    time.Time
+	clients []map[string]string
+}
+
+func NewClients(vendors *oui.DB, aliases *aliases.Aliases) *Clients {
+	return &Clients{vendors: vendors, aliases: aliases}
+}
+
+// SetRefresh has requests call refresh to read the attached clients again when the last
+// ones are older than maxAge, so the API does not depend on scrapes of the metrics
+func (c *Clients) SetRefresh(refresh func(), maxAge time.Duration) {
+	c.refresh = refresh
+	c.maxAge = maxAge
+}
+
+// Observe remembers the attached clients (GetAttachDevice fields)
+func (c *Clients) Observe(clients []map[string]string, now time.Time) {
+	c.mutex.Lock()
+	c.clients = clients
+	c.time = now
+	c.mutex.Unlock()
+}
+
+/* Reads the attached clients again when there are none yet or they are too old */
+func (c *Clients) refreshStale() {
+	if c.refresh == nil {
+		return
+	}
+	c.mutex.RLock()
+	stale := time.Since(c.time) > c.maxAge
+	c.mutex.RUnlock()
+	if stale {
+		c.refresh()
+	}
+}
+
+func (c *Clients) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var name *regexp.Regexp
+	if query.Get("name") != "" {
+		var err error
+		name, err = regexp.Compile(query.Get("name"))
+		if err != nil {
+			http.Error(w, "Invalid name regular expression: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	/* The per-client series filter already knows how to match MACs and connection types */
+	filter, err := filters.NewClientsFilter(filters.ClientsFilterOptions{
+		IncludeMACs:            splitList(query.Get("mac")),
+		IncludeConnectionTypes: splitList(query.Get("connection_type")),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ip := query.Get("ip")
+	groups := splitList(query.Get("group"))
+
+	c.refreshStale()
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.clients == nil {
+		http.Error(w, "No clients collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	response := ClientsResponse{Time: c.time, Clients: []Client{}}
+	for _, device := range c.clients {
+		client := c.newClient(device)
+		if !filter.Enabled(client.MAC, client.Name, client.ConnectionType) {
+			continue
+		}
+		if ip != "" && client.IP != ip {
+			continue
+		}
+		if name != nil && !name.MatchString(client.Name) && !name.MatchString(client.Alias) {
+			continue
+		}
+		if len(groups) > 0 && !contains(groups, client.Group) {
+			continue
+		}
+		response.Clients = append(response.Clients, client)
+	}
+
+	sort.Slice(response.Clients, func(i, j int) bool {
+		return response.Clients[i].MAC < response.Clients[j].MAC
+	})
+	writeJSON(w, response)
+}
+
+func (c *Clients) newClient(device map[string]string) Client {
+	mac := filters.NormalizeMAC(device["MACAddress"])
+	alias, _ := c.aliases.Lookup(mac)
+	return Client{
+		MAC:            mac,
+		IP:             device["IPAddress"],
+		Name:           device["Name"],
+		ConnectionType: device["ConnectionType"],
+		Vendor:         c.vendors.Lookup(mac),
+		Alias:          alias.Alias,
+		Owner:          alias.Owner,
+		Group:          alias.Group,
+
+		WirelessLinkSpeedMbps:         parseFloat(device["WirelessLinkSpeed"]),
+		WirelessSignalStrengthPercent: parseFloat(device["WirelessSignalStrength"]),
+
+		SSID:         device["SSID"],
+		APMAC:        device["ConnAPMAC"],
+		DeviceType:   device["DeviceType"],
+		AllowOrBlock: device["AllowOrBlock"],
+	}
+}
+
+// Stats remembers the stats of the last scrape of the SystemInfo or Traffic collector
+type Stats struct {
+	refresh func()
+	maxAge  time.Duration
+
+	mutex sync.RWMutex
+	time  time.Time
+	stats map[string]float64
+}
+
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+// SetRefresh has requests call refresh to read the stats again when the last ones are
+// older than maxAge
+func (s *Stats) SetRefresh(refresh func(), maxAge time.Duration) {
+	s.refresh = refresh
+	s.maxAge = maxAge
+}
+
+// ObserveStats remembers the parsed stats of the collector
+func (s *Stats) ObserveStats(stats map[string]float64, now time.Time) {
+	s.mutex.Lock()
+	s.stats = stats
+	s.time = now
+	s.mutex.Unlock()
+}
+
+/* The collectors hand over a new map on every scrape, so it can be read without the lock */
+func (s *Stats) snapshot() (map[string]float64, time.Time) {
+	s.mutex.RLock()
+	stats, now := s.stats, s.time
+	s.mutex.RUnlock()
+	if s.refresh == nil || time.Since(now) <= s.maxAge {
+		return stats, now
+	}
+
+	/* Too old or none yet - read them again */
+	s.refresh()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.stats, s.time
+}
+
+func statValue(stats map[string]float64, name string) *float64 {
+	if value, found := stats[name]; found {
+		return &value
+	}
+	return nil
+}
+
+// SystemHandler serves the system info of the last scrape
+func SystemHandler(s *Stats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, now := s.snapshot()
+		if stats == nil {
+			http.Error(w, "No system info collected yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJSON(w, SystemResponse{
+			Time: now,
+			System: System{
+				CPUUtilizationPercent:    statValue(stats, "CPUUtilization"),
+				PhysicalMemoryMB:         statValue(stats, "PhysicalMemory"),
+				MemoryUtilizationPercent: statValue(stats, "MemoryUtilization"),
+				PhysicalFlashMB:          statValue(stats, "PhysicalFlash"),
+				AvailableFlashMB:         statValue(stats, "AvailableFlash"),
+			},
+		})
+	})
+}
+
+// TrafficHandler serves the traffic statistics of the last scrape, limited to the
+// periods (today, yesterday, week, month, last_month) of the comma separated period
+// query parameter
+func TrafficHandler(s *Stats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		periods := splitList(r.URL.Query().Get("period"))
+		for _, period := range periods {
+			if !validPeriod(period) {
+				http.Error(w, "Unknown period "+period, http.StatusBadRequest)
+				return
+			}
+		}
+
+		stats, now := s.snapshot()
+		if stats == nil {
+			http.Error(w, "No traffic statistics collected yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		response := TrafficResponse{Time: now, Traffic: []TrafficPeriod{}}
+		for _, period := range trafficPeriods {
+			if len(periods) > 0 && !contains(periods, period.label) {
+				continue
+			}
+			response.Traffic = append(response.Traffic, TrafficPeriod{
+				Period:               period.label,
+				DownloadBytes:        statValue(stats, period.prefix+"Download"),
+				UploadBytes:          statValue(stats, period.prefix+"Upload"),
+				DownloadAverageBytes: statValue(stats, period.prefix+"DownloadAverage"),
+				UploadAverageBytes:   statValue(stats, period.prefix+"UploadAverage"),
+				ConnectionSeconds:    statValue(stats, period.prefix+"ConnectionTime"),
+			})
+		}
+		writeJSON(w, response)
+	})
+}
+
+func validPeriod(label string) bool {
+	for _, period := range trafficPeriods {
+		if period.label == label {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("failed to write API response", slog.String("error", err.Error()))
+	}
+}
+
+func parseFloat(value string) *float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/oui"
+)
+
+func get(t *testing.T, handler http.Handler, url string, response interface{}) int {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != http.StatusOK {
+		return w.Code
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s: got content type %s", url, ct)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code
+}
+
+func TestClients(t *testing.T) {
+	aliasFile := filepath.Join(t.TempDir(), "aliases.csv")
+	if err := os.WriteFile(aliasFile, []byte("AA:BB:CC:00:00:02,Family NAS,,servers\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deviceAliases, err := aliases.New(aliasFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendors, err := oui.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := NewClients(vendors, deviceAliases)
+	var response ClientsResponse
+	if code := get(t, clients, "/api/v1/clients", &response); code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d before the first scrape", code)
+	}
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clients.Observe([]map[string]string{
+		{"MACAddress": "aa:bb:cc:00:00:02", "Name": "nas", "IPAddress": "192.168.1.20", "ConnectionType": "wired"},
+		{"MACAddress": "AA:BB:CC:00:00:01", "Name": "laptop", "IPAddress": "192.168.1.10", "ConnectionType": "5G", "WirelessLinkSpeed": "866", "WirelessSignalStrength": "71"},
+	}, now)
+
+	if code := get(t, clients, "/api/v1/clients", &response); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	speed, strength := float64(866), float64(71)
+	want := ClientsResponse{Time: now, Clients: []Client{
+		{MAC: "AA:BB:CC:00:00:01", IP: "192.168.1.10", Name: "laptop", ConnectionType: "5G", WirelessLinkSpeedMbps: &speed, WirelessSignalStrengthPercent: &strength},
+		{MAC: "AA:BB:CC:00:00:02", IP: "192.168.1.20", Name: "nas", ConnectionType: "wired", Alias: "Family NAS", Group: "servers"},
+	}}
+	if !reflect.DeepEqual(response, want) {
+		t.Errorf("got %+v, want %+v", response, want)
+	}
+
+	tests := map[string][]string{
+		"/api/v1/clients?name=^lap":                 {"AA:BB:CC:00:00:01"},
+		"/api/v1/clients?name=family":               {},
+		"/api/v1/clients?name=(?i)family":           {"AA:BB:CC:00:00:02"},
+		"/api/v1/clients?ip=192.168.1.20":           {"AA:BB:CC:00:00:02"},
+		"/api/v1/clients?mac=aa-bb-cc-00-00-01":     {"AA:BB:CC:00:00:01"},
+		"/api/v1/clients?connection_type=wireless":  {"AA:BB:CC:00:00:01"},
+		"/api/v1/clients?group=servers,kids":        {"AA:BB:CC:00:00:02"},
+		"/api/v1/clients?group=kids":                {},
+		"/api/v1/clients?connection_type=wired&ip=": {"AA:BB:CC:00:00:02"},
+	}
+	for url, wantMACs := range tests {
+		var response ClientsResponse
+		if code := get(t, clients, url, &response); code != http.StatusOK {
+			t.Errorf("%s: got status %d", url, code)
+			continue
+		}
+		macs := []string{}
+		for _, client := range response.Clients {
+			macs = append(macs, client.MAC)
+		}
+		if !reflect.DeepEqual(macs, wantMACs) {
+			t.Errorf("%s: got %v, want %v", url, macs, wantMACs)
+		}
+	}
+
+	if code := get(t, clients, "/api/v1/clients?name=(", &response); code != http.StatusBadRequest {
+		t.Errorf("got status %d for a bad regular expression", code)
+	}
+}
+
+func TestSystem(t *testing.T) {
+	stats := NewStats()
+	handler := SystemHandler(stats)
+
+	var response SystemResponse
+	if code := get(t, handler, "/api/v1/system", &response); code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d before the first scrape", code)
+	}
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	stats.ObserveStats(map[string]float64{"CPUUtilization": 7, "PhysicalMemory": 512, "MemoryUtilization": 40}, now)
+	if code := get(t, handler, "/api/v1/system", &response); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if response.Time != now || *response.System.CPUUtilizationPercent != 7 || *response.System.PhysicalMemoryMB != 512 || response.System.AvailableFlashMB != nil {
+		t.Errorf("got %+v", response)
+	}
+}
+
+func TestSystemRefreshes(t *testing.T) {
+	stats := NewStats()
+	var refreshes int
+	stats.SetRefresh(func() {
+		refreshes++
+		stats.ObserveStats(map[string]float64{"CPUUtilization": 7}, time.Now())
+	}, time.Minute)
+	handler := SystemHandler(stats)
+
+	var response SystemResponse
+	if code := get(t, handler, "/api/v1/system", &response); code != http.StatusOK || refreshes != 1 {
+		t.Fatalf("got status %d after %d refreshes before the first scrape", code, refreshes)
+	}
+	get(t, handler, "/api/v1/system", &response)
+	if refreshes != 1 {
+		t.Errorf("refreshed %d times while the stats were current", refreshes)
+	}
+
+	stats.ObserveStats(map[string]float64{"CPUUtilization": 99}, time.Now().Add(-2*time.Minute))
+	get(t, handler, "/api/v1/system", &response)
+	if refreshes != 2 || *response.System.CPUUtilizationPercent != 7 {
+		t.Errorf("got %+v after %d refreshes of old stats", response, refreshes)
+	}
+}
+
+func TestTraffic(t *testing.T) {
+	stats := NewStats()
+	handler := TrafficHandler(stats)
+	stats.ObserveStats(map[string]float64{
+		"TodayDownload":          1500000,
+		"TodayUpload":            250000,
+		"TodayConnectionTime":    3600,
+		"WeekDownload":           9000000,
+		"WeekDownloadAverage":    1285714,
+		"LastMonthUpload":        12000000,
+		"LastMonthUploadAverage": 400000,
+	}, time.Now())
+
+	var response TrafficResponse
+	if code := get(t, handler, "/api/v1/traffic", &response); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	var periods []string
+	for _, period := range response.Traffic {
+		periods = append(periods, period.Period)
+	}
+	if !reflect.DeepEqual(periods, []string{"today", "yesterday", "week", "month", "last_month"}) {
+		t.Errorf("got periods %v", periods)
+	}
+
+	if code := get(t, handler, "/api/v1/traffic?period=today,week", &response); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if len(response.Traffic) != 2 {
+		t.Fatalf("got %+v", response.Traffic)
+	}
+	today, week := response.Traffic[0], response.Traffic[1]
+	if *today.DownloadBytes != 1500000 || *today.UploadBytes != 250000 || *today.ConnectionSeconds != 3600 || today.DownloadAverageBytes != nil {
+		t.Errorf("got %+v for today", today)
+	}
+	if *week.DownloadAverageBytes != 1285714 || week.UploadBytes != nil {
+		t.Errorf("got %+v for the week", week)
+	}
+
+	if code := get(t, handler, "/api/v1/traffic?period=decade", &response); code != http.StatusBadRequest {
+		t.Errorf("got status %d for an unknown period", code)
+	}
+}
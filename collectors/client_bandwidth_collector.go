@@ -0,0 +1,212 @@
+package collectors
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_client"
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ClientBandwidthCollector struct {
+	namespace      string
+	client         *netgear_client.NetgearClient
+	unitBytes      float64
+	filter         *filters.ClientsFilter
+	transmitMetric *prometheus.CounterVec
+	receiveMetric  *prometheus.CounterVec
+
+	mutex       sync.Mutex
+	transmitted map[string]*resettingCounter
+	received    map[string]*resettingCounter
+
+	scrapesTotalMetric              prometheus.Counter
+	scrapeErrorsTotalMetric         prometheus.Counter
+	lastScrapeErrorMetric           prometheus.Gauge
+	lastScrapeTimestampMetric       prometheus.Gauge
+	lastScrapeDurationSecondsMetric prometheus.Gauge
+}
+
+// NewClientBandwidthCollector creates the collector. unitBytes is the number of bytes
+// in the unit the router reports device usage in, the same as for the traffic meter.
+// filter selects the clients that are tracked.
+func NewClientBandwidthCollector(namespace string, client *netgear_client.NetgearClient, unitBytes float64, filter *filters.ClientsFilter) *ClientBandwidthCollector {
+	transmitMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "transmit_bytes_total",
+			Help:      "Bytes uploaded by the client with a MAC address label.",
+		},
+		[]string{"mac"},
+	)
+
+	receiveMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "receive_bytes_total",
+			Help:      "Bytes downloaded by the client with a MAC address label.",
+		},
+		[]string{"mac"},
+	)
+
+	scrapesTotalMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client_bandwidth_scrapes",
+			Name:      "total",
+			Help:      "Total number of scrapes for Netgear client bandwidth stats.",
+		},
+	)
+
+	scrapeErrorsTotalMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "client_bandwidth_scrape_errors",
+			Name:      "total",
+			Help:      "Total number of scrapes errors for Netgear client bandwidth stats.",
+		},
+	)
+
+	lastScrapeErrorMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_client_bandwidth_scrape_error",
+			Help:      "Whether the last scrape of Netgear client bandwidth stats resulted in an error (1 for error, 0 for success).",
+		},
+	)
+
+	lastScrapeTimestampMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_client_bandwidth_scrape_timestamp",
+			Help:      "Number of seconds since 1970 since last scrape of Netgear client bandwidth metrics.",
+		},
+	)
+
+	lastScrapeDurationSecondsMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_client_bandwidth_scrape_duration_seconds",
+			Help:      "Duration of the last scrape of Netgear client bandwidth stats.",
+		},
+	)
+
+	return &ClientBandwidthCollector{
+		namespace:      namespace,
+		client:         client,
+		unitBytes:      unitBytes,
+		filter:         filter,
+		transmitMetric: transmitMetric,
+		receiveMetric:  receiveMetric,
+		transmitted:    make(map[string]*resettingCounter),
+		received:       make(map[string]*resettingCounter),
+
+		scrapesTotalMetric:              scrapesTotalMetric,
+		scrapeErrorsTotalMetric:         scrapeErrorsTotalMetric,
+		lastScrapeErrorMetric:           lastScrapeErrorMetric,
+		lastScrapeTimestampMetric:       lastScrapeTimestampMetric,
+		lastScrapeDurationSecondsMetric: lastScrapeDurationSecondsMetric,
+	}
+}
+
+func (c *ClientBandwidthCollector) Collect(ch chan<- prometheus.Metric) {
+	var begun = time.Now()
+	requestID := newRequestID()
+
+	errorMetric := float64(0)
+	devices, err := c.client.GetAttachDevice2()
+	if err != nil {
+		slog.Error("error while collecting client bandwidth statistics", slog.String("request_id", requestID), slog.String("error", err.Error()))
+		errorMetric = float64(1)
+		countScrapeError(c.scrapeErrorsTotalMetric, requestID)
+	} else {
+		c.update(devices, begun)
+	}
+	c.transmitMetric.Collect(ch)
+	c.receiveMetric.Collect(ch)
+
+	c.scrapeErrorsTotalMetric.Collect(ch)
+
+	c.scrapesTotalMetric.Inc()
+	c.scrapesTotalMetric.Collect(ch)
+
+	c.lastScrapeErrorMetric.Set(errorMetric)
+	c.lastScrapeErrorMetric.Collect(ch)
+
+	c.lastScrapeTimestampMetric.Set(float64(time.Now().Unix()))
+	c.lastScrapeTimestampMetric.Collect(ch)
+
+	c.lastScrapeDurationSecondsMetric.Set(time.Since(begun).Seconds())
+	c.lastScrapeDurationSecondsMetric.Collect(ch)
+}
+
+// The router only reports how much each device used so far, which starts over now and
+// then. Turn that into proper counters by adding up the growth between scrapes.
+func (c *ClientBandwidthCollector) update(devices []map[string]string, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	attached := make(map[string]bool)
+	for _, device := range selectClients(c.filter, devices, "MAC", "Name") {
+		mac := strings.ToUpper(device["MAC"])
+		if mac == "" {
+			continue
+		}
+
+		upload, uploadErr := strconv.ParseFloat(device["Upload"], 64)
+		download, downloadErr := strconv.ParseFloat(device["Download"], 64)
+		if uploadErr != nil || downloadErr != nil {
+			slog.Debug(fmt.Sprintf("client '%s' has no usable usage statistics", mac), slog.String("upload", device["Upload"]), slog.String("download", device["Download"]))
+			continue
+		}
+		attached[mac] = true
+
+		addUsage(c.transmitted, c.transmitMetric, mac, upload*c.unitBytes, now)
+		addUsage(c.received, c.receiveMetric, mac, download*c.unitBytes, now)
+	}
+
+	/* Forget clients that left so a busy guest network does not pile up series */
+	for mac := range c.transmitted {
+		if !attached[mac] {
+			delete(c.transmitted, mac)
+			delete(c.received, mac)
+			c.transmitMetric.DeleteLabelValues(mac)
+			c.receiveMetric.DeleteLabelValues(mac)
+		}
+	}
+}
+
+func addUsage(counters map[string]*resettingCounter, metric *prometheus.CounterVec, mac string, bytes float64, now time.Time) {
+	counter, found := counters[mac]
+	if !found {
+		/* First time the client is seen - start from what the router counted so far */
+		counter = &resettingCounter{}
+		counters[mac] = counter
+		counter.observe(bytes, now)
+		metric.WithLabelValues(mac).Add(bytes)
+		return
+	}
+
+	delta, _, _ := counter.observe(bytes, now)
+	metric.WithLabelValues(mac).Add(delta)
+}
+
+func (c *ClientBandwidthCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.transmitMetric.Describe(ch)
+	c.receiveMetric.Describe(ch)
+	c.scrapesTotalMetric.Describe(ch)
+	c.scrapeErrorsTotalMetric.Describe(ch)
+	c.lastScrapeErrorMetric.Describe(ch)
+	c.lastScrapeTimestampMetric.Describe(ch)
+	c.lastScrapeDurationSecondsMetric.Describe(ch)
+}
@@ -0,0 +1,105 @@
+package collectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClientBandwidthUpdate(t *testing.T) {
+	type usage struct{ transmitted, received float64 }
+
+	tests := []struct {
+		name      string
+		unitBytes float64
+		scrapes   [][]map[string]string
+		want      map[string]usage
+	}{
+		{
+			name:      "growth is added",
+			unitBytes: 1,
+			scrapes: [][]map[string]string{
+				{{"MAC": "aa:bb:cc:00:00:01", "Upload": "10", "Download": "100"}},
+				{{"MAC": "aa:bb:cc:00:00:01", "Upload": "15", "Download": "250"}},
+			},
+			want: map[string]usage{"AA:BB:CC:00:00:01": {15, 250}},
+		},
+		{
+			name:      "a reset counts the whole reading",
+			unitBytes: 1,
+			scrapes: [][]map[string]string{
+				{{"MAC": "AA:BB:CC:00:00:01", "Upload": "10", "Download": "100"}},
+				{{"MAC": "AA:BB:CC:00:00:01", "Upload": "40", "Download": "300"}},
+				{{"MAC": "AA:BB:CC:00:00:01", "Upload": "2", "Download": "20"}},
+			},
+			want: map[string]usage{"AA:BB:CC:00:00:01": {42, 320}},
+		},
+		{
+			name:      "clients that left are forgotten and start over when they return",
+			unitBytes: 1,
+			scrapes: [][]map[string]string{
+				{
+					{"MAC": "AA:BB:CC:00:00:01", "Upload": "10", "Download": "100"},
+					{"MAC": "AA:BB:CC:00:00:02", "Upload": "5", "Download": "50"},
+				},
+				{{"MAC": "AA:BB:CC:00:00:02", "Upload": "6", "Download": "60"}},
+				{
+					{"MAC": "AA:BB:CC:00:00:01", "Upload": "12", "Download": "120"},
+					{"MAC": "AA:BB:CC:00:00:02", "Upload": "8", "Download": "80"},
+				},
+			},
+			want: map[string]usage{"AA:BB:CC:00:00:01": {12, 120}, "AA:BB:CC:00:00:02": {8, 80}},
+		},
+		{
+			name:      "the router unit is scaled to bytes",
+			unitBytes: 1000000,
+			scrapes: [][]map[string]string{
+				{{"MAC": "AA:BB:CC:00:00:01", "Upload": "1.5", "Download": "20"}},
+				{{"MAC": "AA:BB:CC:00:00:01", "Upload": "2", "Download": "20.25"}},
+			},
+			want: map[string]usage{"AA:BB:CC:00:00:01": {2000000, 20250000}},
+		},
+		{
+			name:      "clients without usable statistics are skipped",
+			unitBytes: 1,
+			scrapes: [][]map[string]string{
+				{
+					{"MAC": "AA:BB:CC:00:00:01", "Upload": "10", "Download": "100"},
+					{"MAC": "AA:BB:CC:00:00:02", "Upload": "--", "Download": "50"},
+					{"MAC": "", "Upload": "1", "Download": "1"},
+				},
+			},
+			want: map[string]usage{"AA:BB:CC:00:00:01": {10, 100}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, _ := filters.NewClientsFilter(filters.ClientsFilterOptions{})
+			c := NewClientBandwidthCollector("netgear", nil, test.unitBytes, filter)
+
+			now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+			for _, devices := range test.scrapes {
+				c.update(devices, now)
+				now = now.Add(time.Minute)
+			}
+
+			if got := testutil.CollectAndCount(c.transmitMetric); got != len(test.want) {
+				t.Errorf("got %d transmit series, want %d", got, len(test.want))
+			}
+			if got := testutil.CollectAndCount(c.receiveMetric); got != len(test.want) {
+				t.Errorf("got %d receive series, want %d", got, len(test.want))
+			}
+			for mac, want := range test.want {
+				if got := testutil.ToFloat64(c.transmitMetric.WithLabelValues(mac)); got != want.transmitted {
+					t.Errorf("got %v bytes transmitted by %s, want %v", got, mac, want.transmitted)
+				}
+				if got := testutil.ToFloat64(c.receiveMetric.WithLabelValues(mac)); got != want.received {
+					t.Errorf("got %v bytes received by %s, want %v", got, mac, want.received)
+				}
+			}
+		})
+	}
+}
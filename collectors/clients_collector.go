@@ -2,19 +2,43 @@ package collectors
 
 import (
 	"log/slog"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/DRuggeri/netgear_client"
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/DRuggeri/netgear_exporter/oui"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// ClientObserver is handed the attached clients (GetAttachDevice fields) on every scrape
+type ClientObserver interface {
+	Observe(clients []map[string]string, now time.Time)
+}
+
 type ClientCollector struct {
 	namespace              string
 	client                 *netgear_client.NetgearClient
+	detailed               bool
+	filter                 *filters.ClientsFilter
+	vendors                *oui.DB
+	aliases                *aliases.Aliases
+	observers              []ClientObserver
+	mutex                  sync.Mutex
 	clientsMetric          *prometheus.GaugeVec
+	countMetric            *prometheus.GaugeVec
+	attachedMetric         prometheus.Gauge
+	filteredMetric         prometheus.Gauge
+	groupMetric            *prometheus.GaugeVec
+	detailMetric           *prometheus.GaugeVec
 	wirelessSpeedMetric    *prometheus.GaugeVec
 	wirelessStrengthMetric *prometheus.GaugeVec
+	wirelessSpeedDesc      *prometheus.Desc
+	wirelessStrengthDesc   *prometheus.Desc
 
 	scrapesTotalMetric              prometheus.Counter
 	scrapeErrorsTotalMetric         prometheus.Counter
@@ -23,15 +47,96 @@ type ClientCollector struct {
 	lastScrapeDurationSecondsMetric prometheus.Gauge
 }
 
-func NewClientCollector(namespace string, client *netgear_client.NetgearClient) *ClientCollector {
+/* Buckets for the per band distributions in base units. Netgear reports link speed in Mbps and signal strength in percent */
+var (
+	wirelessSpeedBuckets    = []float64{6e6, 24e6, 54e6, 144e6, 300e6, 600e6, 866e6, 1200e6, 2400e6, 4800e6}
+	wirelessStrengthBuckets = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
+)
+
+// NewClientCollector creates the collector. detailed reads the attached devices with
+// GetAttachDevice2, which newer firmware answers with SSID, band, access point, device
+// type and allow/block status. filter selects the clients with per-client series, the
+// client counts always include every attached client. vendors resolves the vendor label
+// and aliases the alias, owner and group labels. The attached clients are handed to the
+// observers after every successful scrape.
+func NewClientCollector(namespace string, client *netgear_client.NetgearClient, detailed bool, filter *filters.ClientsFilter, vendors *oui.DB, aliases *aliases.Aliases, observers ...ClientObserver) *ClientCollector {
+	clientsHelp := "Client information with ip, name, MAC address, connection type, randomized MAC address, vendor, alias, owner and group labels"
+	clientsLabels := []string{"ip", "name", "mac", "connection_type", "randomized", "vendor", "alias", "owner", "group"}
+	if filter.DropIdentityLabels() {
+		clientsHelp = "Client information with MAC address, connection type, randomized MAC address, vendor, alias, owner and group labels"
+		clientsLabels = clientsLabels[2:]
+	}
+
 	clientsMetric := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
 			Subsystem: "client",
 			Name:      "info",
-			Help:      "Client information with ip, name, MAC address and connection type labels",
+			Help:      clientsHelp,
+		},
+		clientsLabels,
+	)
+
+	countMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "clients",
+			Help:      "Number of clients connected to the network with connection type and band labels",
+		},
+		[]string{"connection_type", "band"},
+	)
+
+	attachedMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "clients",
+			Name:      "attached",
+			Help:      "Number of clients connected to the network",
+		},
+	)
+
+	filteredMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "clients",
+			Name:      "filtered",
+			Help:      "Number of clients connected to the network without per-client series because of the client filters",
+		},
+	)
+
+	groupMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "group",
+			Name:      "clients_connected",
+			Help:      "Number of clients of the group in the alias file connected to the network",
 		},
-		[]string{"ip", "name", "mac", "connection_type"},
+		[]string{"group"},
+	)
+
+	detailMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "client",
+			Name:      "detail_info",
+			Help:      "Client details reported by newer firmware with MAC address, SSID, band, access point MAC address, device type and allow/block status labels",
+		},
+		[]string{"mac", "ssid", "band", "ap_mac", "device_type", "allow_or_block"},
+	)
+
+	wirelessSpeedDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "client", "wireless_link_speed_bits_per_second"),
+		"Distribution of the wireless link speed (bits per second) of the clients connected to the network by band",
+		[]string{"band"},
+		nil,
+	)
+
+	wirelessStrengthDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "client", "wireless_signal_strength_ratio"),
+		"Distribution of the wireless signal strength (0-1) of the clients connected to the network by band",
+		[]string{"band"},
+		nil,
 	)
 
 	wirelessSpeedMetric := prometheus.NewGaugeVec(
@@ -102,9 +207,21 @@ func NewClientCollector(namespace string, client *netgear_client.NetgearClient)
 	return &ClientCollector{
 		namespace:              namespace,
 		client:                 client,
+		detailed:               detailed,
+		filter:                 filter,
+		vendors:                vendors,
+		aliases:                aliases,
+		observers:              observers,
 		clientsMetric:          clientsMetric,
+		countMetric:            countMetric,
+		attachedMetric:         attachedMetric,
+		filteredMetric:         filteredMetric,
+		groupMetric:            groupMetric,
+		detailMetric:           detailMetric,
 		wirelessSpeedMetric:    wirelessSpeedMetric,
 		wirelessStrengthMetric: wirelessStrengthMetric,
+		wirelessSpeedDesc:      wirelessSpeedDesc,
+		wirelessStrengthDesc:   wirelessStrengthDesc,
 
 		scrapesTotalMetric:              scrapesTotalMetric,
 		scrapeErrorsTotalMetric:         scrapeErrorsTotalMetric,
@@ -116,34 +233,21 @@ func NewClientCollector(namespace string, client *netgear_client.NetgearClient)
 
 func (c *ClientCollector) Collect(ch chan<- prometheus.Metric) {
 	var begun = time.Now()
+	requestID := newRequestID()
 
 	errorMetric := float64(0)
-	clients, err := c.client.GetAttachDevice()
+	clients, err := c.attachedDevices()
 	if err != nil {
-		slog.Error("error while collecting client statistics: %v", slog.String("error", err.Error()))
+		slog.Error("error while collecting client statistics", slog.String("request_id", requestID), slog.String("error", err.Error()))
 		errorMetric = float64(1)
-		c.scrapeErrorsTotalMetric.Inc()
+		countScrapeError(c.scrapeErrorsTotalMetric, requestID)
+		c.collectClients(ch, nil)
 	} else {
-		for _, client := range clients {
-			c.clientsMetric.WithLabelValues(
-				client["IPAddress"],
-				client["Name"],
-				client["MACAddress"],
-				client["ConnectionType"],
-			).Set(float64(1))
-
-			if client["ConnectionType"] != "wired" {
-				tmp, _ := strconv.ParseFloat(client["WirelessLinkSpeed"], 64)
-				c.wirelessSpeedMetric.WithLabelValues(client["MACAddress"]).Set(tmp)
-
-				tmp, _ = strconv.ParseFloat(client["WirelessSignalStrength"], 64)
-				c.wirelessStrengthMetric.WithLabelValues(client["MACAddress"]).Set(tmp)
-			}
+		for _, observer := range c.observers {
+			observer.Observe(clients, begun)
 		}
+		c.collectClients(ch, clients)
 	}
-	c.clientsMetric.Collect(ch)
-	c.wirelessSpeedMetric.Collect(ch)
-	c.wirelessStrengthMetric.Collect(ch)
 
 	c.scrapeErrorsTotalMetric.Collect(ch)
 
@@ -160,10 +264,271 @@ func (c *ClientCollector) Collect(ch chan<- prometheus.Metric) {
 	c.lastScrapeDurationSecondsMetric.Collect(ch)
 }
 
+// Read reads the attached clients and hands them to observers only, without touching
+// the metrics or the observers of the scrapes
+func (c *ClientCollector) Read(observers ...ClientObserver) error {
+	begun := time.Now()
+	clients, err := c.attachedDevices()
+	if err != nil {
+		return err
+	}
+	for _, observer := range observers {
+		observer.Observe(clients, begun)
+	}
+	return nil
+}
+
+/* Rebuild the series from the attached clients, or keep the previous ones when clients is nil, and collect them while no other scrape rebuilds them */
+func (c *ClientCollector) collectClients(ch chan<- prometheus.Metric, clients []map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if clients != nil {
+		speeds, strengths := c.setClients(clients)
+		speeds.collect(ch, c.wirelessSpeedDesc)
+		strengths.collect(ch, c.wirelessStrengthDesc)
+	}
+	c.clientsMetric.Collect(ch)
+	c.countMetric.Collect(ch)
+	c.attachedMetric.Collect(ch)
+	c.filteredMetric.Collect(ch)
+	c.groupMetric.Collect(ch)
+	if c.detailed {
+		c.detailMetric.Collect(ch)
+	}
+	c.wirelessSpeedMetric.Collect(ch)
+	c.wirelessStrengthMetric.Collect(ch)
+}
+
+/* Update the metrics from the attached clients and summarize their wireless link per band */
+func (c *ClientCollector) setClients(clients []map[string]string) (*bandDistribution, *bandDistribution) {
+	speeds := newBandDistribution(wirelessSpeedBuckets)
+	strengths := newBandDistribution(wirelessStrengthBuckets)
+
+	/* Start over on every scrape so clients that left do not linger */
+	c.clientsMetric.Reset()
+	c.countMetric.Reset()
+	c.groupMetric.Reset()
+	c.detailMetric.Reset()
+	c.wirelessSpeedMetric.Reset()
+	c.wirelessStrengthMetric.Reset()
+
+	type connection struct{ connectionType, band string }
+	counts := make(map[connection]int)
+	groups := make(map[string]int)
+	for _, group := range c.aliases.Groups() {
+		groups[group] = 0
+	}
+
+	for _, client := range clients {
+		band := clientBand(client["ConnectionType"])
+		counts[connection{client["ConnectionType"], band}]++
+		if alias, found := c.aliases.Lookup(client["MACAddress"]); found && alias.Group != "" {
+			groups[alias.Group]++
+		}
+
+		if client["ConnectionType"] == "wired" {
+			continue
+		}
+		if speed, err := strconv.ParseFloat(client["WirelessLinkSpeed"], 64); err == nil {
+			speeds.observe(band, speed*1e6)
+		}
+		if strength, err := strconv.ParseFloat(client["WirelessSignalStrength"], 64); err == nil {
+			strengths.observe(band, strength/100)
+		}
+	}
+
+	for connection, count := range counts {
+		c.countMetric.WithLabelValues(connection.connectionType, connection.band).Set(float64(count))
+	}
+	for group, count := range groups {
+		c.groupMetric.WithLabelValues(group).Set(float64(count))
+	}
+
+	selected := selectClients(c.filter, clients, "MACAddress", "Name")
+	for _, client := range selected {
+		alias, _ := c.aliases.Lookup(client["MACAddress"])
+		labels := []string{
+			client["IPAddress"],
+			client["Name"],
+			client["MACAddress"],
+			client["ConnectionType"],
+			strconv.FormatBool(randomizedMAC(client["MACAddress"])),
+			c.vendors.Lookup(client["MACAddress"]),
+			alias.Alias,
+			alias.Owner,
+			alias.Group,
+		}
+		if c.filter.DropIdentityLabels() {
+			labels = labels[2:]
+		}
+		c.clientsMetric.WithLabelValues(labels...).Set(float64(1))
+
+		if c.detailed {
+			c.detailMetric.WithLabelValues(
+				client["MACAddress"],
+				client["SSID"],
+				clientBand(client["ConnectionType"]),
+				client["ConnAPMAC"],
+				client["DeviceType"],
+				client["AllowOrBlock"],
+			).Set(float64(1))
+		}
+
+		if client["ConnectionType"] != "wired" {
+			tmp, _ := strconv.ParseFloat(client["WirelessLinkSpeed"], 64)
+			c.wirelessSpeedMetric.WithLabelValues(client["MACAddress"]).Set(tmp)
+
+			tmp, _ = strconv.ParseFloat(client["WirelessSignalStrength"], 64)
+			c.wirelessStrengthMetric.WithLabelValues(client["MACAddress"]).Set(tmp)
+		}
+	}
+	c.attachedMetric.Set(float64(len(clients)))
+	c.filteredMetric.Set(float64(len(clients) - len(selected)))
+	return speeds, strengths
+}
+
+// selectClients returns the clients the filter gives per-client series. When there are
+// more than the filter allows, the ones with the lowest MAC addresses are kept so the
+// same clients keep their series from scrape to scrape.
+func selectClients(filter *filters.ClientsFilter, clients []map[string]string, macKey string, nameKey string) []map[string]string {
+	selected := make([]map[string]string, 0, len(clients))
+	for _, client := range clients {
+		if filter.Enabled(client[macKey], client[nameKey], client["ConnectionType"]) {
+			selected = append(selected, client)
+		}
+	}
+
+	if max := filter.MaxSeries(); max > 0 && len(selected) > max {
+		sort.SliceStable(selected, func(i, j int) bool {
+			return filters.NormalizeMAC(selected[i][macKey]) < filters.NormalizeMAC(selected[j][macKey])
+		})
+		slog.Debug("too many clients for per-client series", slog.Int("clients", len(selected)), slog.Int("max", max))
+		selected = selected[:max]
+	}
+	return selected
+}
+
+/* Read the attached devices and present GetAttachDevice2 results with the field names of GetAttachDevice */
+func (c *ClientCollector) attachedDevices() ([]map[string]string, error) {
+	if !c.detailed {
+		return c.client.GetAttachDevice()
+	}
+
+	devices, err := c.client.GetAttachDevice2()
+	if err != nil {
+		return devices, err
+	}
+
+	clients := make([]map[string]string, 0, len(devices))
+	for _, device := range devices {
+		deviceType := device["DeviceTypeName"]
+		if deviceType == "" {
+			deviceType = device["DeviceType"]
+		}
+
+		clients = append(clients, map[string]string{
+			"IPAddress":              device["IP"],
+			"Name":                   device["Name"],
+			"MACAddress":             device["MAC"],
+			"ConnectionType":         device["ConnectionType"],
+			"WirelessLinkSpeed":      device["Linkspeed"],
+			"WirelessSignalStrength": device["SignalStrength"],
+			"SSID":                   device["SSID"],
+			"ConnAPMAC":              device["ConnAPMAC"],
+			"DeviceType":             deviceType,
+			"AllowOrBlock":           device["AllowOrBlock"],
+		})
+	}
+	return clients, nil
+}
+
+// randomizedMAC reports whether the MAC address is locally administered, which is what
+// the private addresses of iOS, Android and Windows are
+func randomizedMAC(mac string) bool {
+	mac = filters.NormalizeMAC(mac)
+	if len(mac) < 2 {
+		return false
+	}
+
+	firstOctet, err := strconv.ParseUint(mac[:2], 16, 8)
+	if err != nil {
+		return false
+	}
+	return firstOctet&0x02 != 0
+}
+
+/* Firmware reports the band as the connection type in several spellings (2.4G, 2.4GHz, 5G, ...) */
+func clientBand(connectionType string) string {
+	t := strings.ToLower(strings.ReplaceAll(connectionType, " ", ""))
+	switch {
+	case t == "wired":
+		return ""
+	case strings.HasPrefix(t, "2.4g"):
+		return "2.4GHz"
+	case strings.HasPrefix(t, "5g"):
+		return "5GHz"
+	case strings.HasPrefix(t, "6g"):
+		return "6GHz"
+	default:
+		return "unknown"
+	}
+}
+
+/* A histogram per band of what the clients attached right now report */
+type bandDistribution struct {
+	buckets []float64
+	bands   map[string]*bandHistogram
+}
+
+type bandHistogram struct {
+	count  uint64
+	sum    float64
+	counts map[float64]uint64
+}
+
+func newBandDistribution(buckets []float64) *bandDistribution {
+	return &bandDistribution{buckets: buckets, bands: make(map[string]*bandHistogram)}
+}
+
+func (d *bandDistribution) observe(band string, value float64) {
+	h, ok := d.bands[band]
+	if !ok {
+		h = &bandHistogram{counts: make(map[float64]uint64, len(d.buckets))}
+		for _, bucket := range d.buckets {
+			h.counts[bucket] = 0
+		}
+		d.bands[band] = h
+	}
+
+	h.count++
+	h.sum += value
+	for _, bucket := range d.buckets {
+		if value <= bucket {
+			h.counts[bucket]++
+		}
+	}
+}
+
+func (d *bandDistribution) collect(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	for band, h := range d.bands {
+		ch <- prometheus.MustNewConstHistogram(desc, h.count, h.sum, h.counts, band)
+	}
+}
+
 func (c *ClientCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.clientsMetric.Describe(ch)
+	c.countMetric.Describe(ch)
+	c.attachedMetric.Describe(ch)
+	c.filteredMetric.Describe(ch)
+	c.groupMetric.Describe(ch)
+	if c.detailed {
+		c.detailMetric.Describe(ch)
+	}
 	c.wirelessSpeedMetric.Describe(ch)
 	c.wirelessStrengthMetric.Describe(ch)
+	ch <- c.wirelessSpeedDesc
+	ch <- c.wirelessStrengthDesc
 	c.scrapesTotalMetric.Describe(ch)
 	c.scrapeErrorsTotalMetric.Describe(ch)
 	c.lastScrapeErrorMetric.Describe(ch)
@@ -0,0 +1,269 @@
+package collectors
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/DRuggeri/netgear_exporter/oui"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestClientBand(t *testing.T) {
+	tests := map[string]string{
+		"wired":    "",
+		"2.4G":     "2.4GHz",
+		"2.4GHz":   "2.4GHz",
+		"5G":       "5GHz",
+		"5 GHz":    "5GHz",
+		"6GHz":     "6GHz",
+		"wireless": "unknown",
+	}
+
+	for connectionType, want := range tests {
+		if got := clientBand(connectionType); got != want {
+			t.Errorf("clientBand(%q) = %q, want %q", connectionType, got, want)
+		}
+	}
+}
+
+func TestRandomizedMAC(t *testing.T) {
+	tests := map[string]bool{
+		"00:11:22:33:44:55": false,
+		"3C:22:FB:00:00:01": false,
+		"DA:A1:19:00:00:01": true,
+		"a6-83-e7-00-00-01": true,
+		"2:11:22:33:44:55":  false,
+		"":                  false,
+		"zz:11:22:33:44:55": false,
+	}
+
+	for mac, want := range tests {
+		if got := randomizedMAC(mac); got != want {
+			t.Errorf("randomizedMAC(%q) = %v, want %v", mac, got, want)
+		}
+	}
+}
+
+func TestBandDistribution(t *testing.T) {
+	desc := prometheus.NewDesc("test_strength", "test", []string{"band"}, nil)
+	d := newBandDistribution([]float64{50, 100})
+	d.observe("5GHz", 40)
+	d.observe("5GHz", 90)
+	d.observe("2.4GHz", 60)
+
+	ch := make(chan prometheus.Metric, 10)
+	d.collect(ch, desc)
+	close(ch)
+
+	histograms := make(map[string]*dto.Histogram)
+	for m := range ch {
+		var out dto.Metric
+		if err := m.Write(&out); err != nil {
+			t.Fatal(err)
+		}
+		histograms[out.GetLabel()[0].GetValue()] = out.GetHistogram()
+	}
+
+	h := histograms["5GHz"]
+	if h.GetSampleCount() != 2 || h.GetSampleSum() != 130 {
+		t.Errorf("5GHz: got count %d sum %v, want 2 and 130", h.GetSampleCount(), h.GetSampleSum())
+	}
+	if got := h.GetBucket()[0].GetCumulativeCount(); got != 1 {
+		t.Errorf("5GHz: got %d in the 50 bucket, want 1", got)
+	}
+
+	h = histograms["2.4GHz"]
+	if h.GetSampleCount() != 1 || h.GetBucket()[0].GetCumulativeCount() != 0 || h.GetBucket()[1].GetCumulativeCount() != 1 {
+		t.Errorf("2.4GHz: unexpected histogram %v", h)
+	}
+}
+
+func TestSetClients(t *testing.T) {
+	filter, _ := filters.NewClientsFilter(filters.ClientsFilterOptions{})
+	vendors, _ := oui.New("")
+	aliasFile := filepath.Join(t.TempDir(), "aliases.csv")
+	if err := os.WriteFile(aliasFile, []byte("00:11:32:00:00:03,NAS,,servers\nAA:BB:CC:00:00:09,Console,Bob,kids\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deviceAliases, err := aliases.New(aliasFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClientCollector("netgear", nil, false, filter, vendors, deviceAliases)
+	speeds, strengths := c.setClients([]map[string]string{
+		{"IPAddress": "10.0.0.2", "Name": "laptop", "MACAddress": "AA:BB:CC:00:00:01", "ConnectionType": "5G", "WirelessLinkSpeed": "866", "WirelessSignalStrength": "70"},
+		{"IPAddress": "10.0.0.3", "Name": "phone", "MACAddress": "AA:BB:CC:00:00:02", "ConnectionType": "5G", "WirelessLinkSpeed": "433", "WirelessSignalStrength": "55"},
+		{"IPAddress": "10.0.0.4", "Name": "nas", "MACAddress": "00:11:32:00:00:03", "ConnectionType": "wired"},
+	})
+
+	/* The distributions are in base units, not the router's Mbps and percent */
+	for _, test := range []struct {
+		distribution *bandDistribution
+		desc         *prometheus.Desc
+		want         float64
+	}{
+		{speeds, c.wirelessSpeedDesc, 1299e6},
+		{strengths, c.wirelessStrengthDesc, 1.25},
+	} {
+		ch := make(chan prometheus.Metric, 10)
+		test.distribution.collect(ch, test.desc)
+		close(ch)
+		var out dto.Metric
+		(<-ch).Write(&out)
+		if got := out.GetHistogram().GetSampleSum(); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: got a sum of %v, want %v", test.desc, got, test.want)
+		}
+	}
+
+	if got := testutil.ToFloat64(c.clientsMetric.WithLabelValues("10.0.0.4", "nas", "00:11:32:00:00:03", "wired", "false", "Synology Incorporated", "NAS", "", "servers")); got != 1 {
+		t.Errorf("got %v for the nas, want 1", got)
+	}
+	if got := testutil.ToFloat64(c.groupMetric.WithLabelValues("servers")); got != 1 {
+		t.Errorf("got %v servers connected, want 1", got)
+	}
+	if got := testutil.ToFloat64(c.groupMetric.WithLabelValues("kids")); got != 0 {
+		t.Errorf("got %v kids devices connected, want 0", got)
+	}
+	if got := testutil.ToFloat64(c.countMetric.WithLabelValues("5G", "5GHz")); got != 2 {
+		t.Errorf("got %v clients on 5GHz, want 2", got)
+	}
+	if got := testutil.ToFloat64(c.countMetric.WithLabelValues("wired", "")); got != 1 {
+		t.Errorf("got %v wired clients, want 1", got)
+	}
+	if got := testutil.ToFloat64(c.attachedMetric); got != 3 {
+		t.Errorf("got %v attached clients, want 3", got)
+	}
+
+	/* The phone left - its series must go */
+	c.setClients([]map[string]string{
+		{"IPAddress": "10.0.0.2", "Name": "laptop", "MACAddress": "AA:BB:CC:00:00:01", "ConnectionType": "5G", "WirelessLinkSpeed": "866", "WirelessSignalStrength": "70"},
+	})
+	if got := testutil.CollectAndCount(c.clientsMetric); got != 1 {
+		t.Errorf("got %d client_info series, want 1", got)
+	}
+	if got := testutil.CollectAndCount(c.wirelessStrengthMetric); got != 1 {
+		t.Errorf("got %d wireless_strength series, want 1", got)
+	}
+	if got := testutil.CollectAndCount(c.countMetric); got != 1 {
+		t.Errorf("got %d clients series, want 1", got)
+	}
+}
+
+func TestSetClientsFiltered(t *testing.T) {
+	filter, err := filters.NewClientsFilter(filters.ClientsFilterOptions{
+		ExcludeConnectionTypes: []string{"wired"},
+		MaxSeries:              1,
+		DropIdentityLabels:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendors, err := oui.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceAliases, err := aliases.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClientCollector("netgear", nil, false, filter, vendors, deviceAliases)
+	c.setClients([]map[string]string{
+		{"IPAddress": "10.0.0.3", "Name": "phone", "MACAddress": "AA:BB:CC:00:00:02", "ConnectionType": "5G", "WirelessLinkSpeed": "433", "WirelessSignalStrength": "55"},
+		{"IPAddress": "10.0.0.2", "Name": "laptop", "MACAddress": "AA:BB:CC:00:00:01", "ConnectionType": "5G", "WirelessLinkSpeed": "866", "WirelessSignalStrength": "70"},
+		{"IPAddress": "10.0.0.4", "Name": "nas", "MACAddress": "AA:BB:CC:00:00:03", "ConnectionType": "wired"},
+	})
+
+	/* Only the lowest MAC address is left and without ip and name */
+	if got := testutil.ToFloat64(c.clientsMetric.WithLabelValues("AA:BB:CC:00:00:01", "5G", "true", "", "", "", "")); got != 1 {
+		t.Errorf("got %v for the laptop, want 1", got)
+	}
+	if got := testutil.CollectAndCount(c.clientsMetric); got != 1 {
+		t.Errorf("got %d client_info series, want 1", got)
+	}
+	if got := testutil.ToFloat64(c.filteredMetric); got != 2 {
+		t.Errorf("got %v filtered clients, want 2", got)
+	}
+
+	/* The counts still cover everything */
+	if got := testutil.ToFloat64(c.attachedMetric); got != 3 {
+		t.Errorf("got %v attached clients, want 3", got)
+	}
+	if got := testutil.ToFloat64(c.countMetric.WithLabelValues("5G", "5GHz")); got != 2 {
+		t.Errorf("got %v clients on 5GHz, want 2", got)
+	}
+}
+
+func TestCollectClientsConcurrently(t *testing.T) {
+	filter, _ := filters.NewClientsFilter(filters.ClientsFilterOptions{})
+	vendors, _ := oui.New("")
+	deviceAliases, _ := aliases.New("")
+	c := NewClientCollector("netgear", nil, false, filter, vendors, deviceAliases)
+	clients := []map[string]string{
+		{"IPAddress": "10.0.0.2", "Name": "laptop", "MACAddress": "AA:BB:CC:00:00:01", "ConnectionType": "5G", "WirelessLinkSpeed": "866", "WirelessSignalStrength": "70"},
+		{"IPAddress": "10.0.0.3", "Name": "phone", "MACAddress": "AA:BB:CC:00:00:02", "ConnectionType": "5G", "WirelessLinkSpeed": "433", "WirelessSignalStrength": "55"},
+	}
+
+	/* Overlapping scrapes must each see the two clients, not the counts of both scrapes */
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ch := make(chan prometheus.Metric, 100)
+				c.collectClients(ch, clients)
+				close(ch)
+
+				for m := range ch {
+					if !strings.Contains(m.Desc().String(), `"netgear_clients"`) {
+						continue
+					}
+					var out dto.Metric
+					m.Write(&out)
+					if got := out.GetGauge().GetValue(); got != 2 {
+						t.Errorf("got %v clients on 5GHz, want 2", got)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientRead(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{
+		"DeviceInfo:1#GetAttachDevice": {fields: map[string]string{"NewAttachDevice": "1@1;10.0.0.2;laptop;AA:BB:CC:00:00:01;5G;866;70"}},
+	})
+	filter, _ := filters.NewClientsFilter(filters.ClientsFilterOptions{})
+	vendors, _ := oui.New("")
+	deviceAliases, _ := aliases.New("")
+	scraped := &recordingObserver{}
+	c := NewClientCollector("netgear", router.netgearClient(t), false, filter, vendors, deviceAliases, scraped)
+
+	/* Service discovery reads the clients without the events and counters of a scrape */
+	read := &recordingObserver{}
+	if err := c.Read(read); err != nil {
+		t.Fatal(err)
+	}
+	if got := read.macs(); len(got) != 1 || got[0] != "AA:BB:CC:00:00:01" {
+		t.Errorf("got clients %v", got)
+	}
+	if scraped.clients != nil {
+		t.Error("the observers of the scrapes were handed the read")
+	}
+	if got := testutil.ToFloat64(c.scrapesTotalMetric); got != 0 {
+		t.Errorf("got %v scrapes after a read, want 0", got)
+	}
+	if got := testutil.CollectAndCount(c.clientsMetric); got != 0 {
+		t.Errorf("got %d client series after a read, want 0", got)
+	}
+}
@@ -0,0 +1,32 @@
+package collectors
+
+import (
+	"time"
+)
+
+// resettingCounter follows a router counter that periodically starts over from
+// zero (daily traffic, a reboot, ...) and reports how much it grew between readings.
+type resettingCounter struct {
+	value float64
+	at    time.Time
+	seen  bool
+}
+
+// observe records a new reading and returns the growth since the previous one and
+// the time that passed. ok is false for the very first reading. A reading below the
+// previous one is treated as a reset, so all of it counts as growth.
+func (c *resettingCounter) observe(value float64, now time.Time) (delta float64, elapsed time.Duration, ok bool) {
+	if c.seen {
+		delta = value - c.value
+		if delta < 0 {
+			delta = value
+		}
+		elapsed = now.Sub(c.at)
+		ok = elapsed > 0
+	}
+
+	c.value = value
+	c.at = now
+	c.seen = true
+	return delta, elapsed, ok
+}
@@ -0,0 +1,30 @@
+package collectors
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResettingCounter(t *testing.T) {
+	begun := time.Unix(1700000000, 0)
+	var c resettingCounter
+
+	if _, _, ok := c.observe(100, begun); ok {
+		t.Error("the first reading must not report growth")
+	}
+
+	delta, elapsed, ok := c.observe(160, begun.Add(30*time.Second))
+	if !ok || delta != 60 || elapsed != 30*time.Second {
+		t.Errorf("want 60 over 30s, have %v over %v (ok=%v)", delta, elapsed, ok)
+	}
+
+	/* Midnight - the counter starts over */
+	delta, _, ok = c.observe(5, begun.Add(60*time.Second))
+	if !ok || delta != 5 {
+		t.Errorf("want the whole reading after a reset, have %v (ok=%v)", delta, ok)
+	}
+
+	if _, _, ok = c.observe(10, begun.Add(60*time.Second)); ok {
+		t.Error("readings without elapsed time must not report growth")
+	}
+}
@@ -0,0 +1,280 @@
+package collectors
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/DRuggeri/netgear_exporter/webhook"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Inventory keeps track of every device the router reported and flags the ones that
+// are not approved. Devices in the approved file or the alias file are approved.
+// Without an approved file, the devices attached when the inventory is first taken
+// are approved as well. The inventory is kept in a state file to survive restarts.
+type Inventory struct {
+	namespace    string
+	approvedFile string
+	aliases      *aliases.Aliases
+	notifier     *webhook.Notifier
+	stateFile    string
+	forgetAfter  time.Duration
+
+	mutex    sync.Mutex
+	approved map[string]bool
+	state    inventoryState
+	/* Devices a notification is on its way for, so a scrape meanwhile does not send another */
+	notifying map[string]bool
+
+	unknownMetric *prometheus.GaugeVec
+}
+
+type inventoryState struct {
+	Taken   bool                       `json:"taken"`
+	Devices map[string]inventoryDevice `json:"devices"`
+}
+
+type inventoryDevice struct {
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Learned   bool      `json:"learned"`
+	Notified  bool      `json:"notified"`
+}
+
+/* The last seen time is saved at most this often, so the state file is not written on every scrape */
+const lastSeenResolution = 24 * time.Hour
+
+// NewInventory creates the inventory. notifier is told about unknown devices the first
+// time they connect and may be nil. The state is loaded from and saved to stateFile
+// unless it is empty. Devices that were not seen for forgetAfter are forgotten, except
+// the ones learned on the first inventory, so that clients with randomized MAC addresses
+// do not fill the state file. A forgotten device that connects again is new. 0 keeps
+// every device.
+func NewInventory(namespace string, approvedFile string, aliases *aliases.Aliases, notifier *webhook.Notifier, stateFile string, forgetAfter time.Duration) (*Inventory, error) {
+	i := &Inventory{
+		namespace:    namespace,
+		approvedFile: approvedFile,
+		aliases:      aliases,
+		notifier:     notifier,
+		stateFile:    stateFile,
+		forgetAfter:  forgetAfter,
+		approved:     make(map[string]bool),
+		state:        inventoryState{Devices: make(map[string]inventoryDevice)},
+		notifying:    make(map[string]bool),
+
+		unknownMetric: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "client",
+				Name:      "unknown",
+				Help:      "Client connected to the network that is not approved with a MAC address label",
+			},
+			[]string{"mac"},
+		),
+	}
+
+	if approvedFile != "" {
+		if err := i.Reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	if stateFile != "" {
+		data, err := os.ReadFile(stateFile)
+		if err == nil {
+			if err := json.Unmarshal(data, &i.state); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	/* A file with "devices": null or one saved without last seen times */
+	if i.state.Devices == nil {
+		i.state.Devices = make(map[string]inventoryDevice)
+	}
+	for mac, device := range i.state.Devices {
+		if device.LastSeen.IsZero() {
+			device.LastSeen = time.Now()
+			i.state.Devices[mac] = device
+		}
+	}
+
+	return i, nil
+}
+
+// Reload reads the approved file again: one MAC address per line, lines starting
+// with # are ignored
+func (i *Inventory) Reload() error {
+	f, err := os.Open(i.approvedFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	approved := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		approved[filters.NormalizeMAC(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("approved file %s: %s", i.approvedFile, err)
+	}
+
+	i.mutex.Lock()
+	i.approved = approved
+	i.mutex.Unlock()
+	return nil
+}
+
+// Observe takes the attached clients (GetAttachDevice fields) into the inventory
+func (i *Inventory) Observe(clients []map[string]string, now time.Time) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	/* The devices present when the inventory is first taken are trusted unless there is an approved list */
+	learn := !i.state.Taken && i.approvedFile == ""
+	changed := !i.state.Taken
+	i.state.Taken = true
+
+	i.unknownMetric.Reset()
+	for _, client := range clients {
+		mac := filters.NormalizeMAC(client["MACAddress"])
+		if mac == "" {
+			continue
+		}
+
+		device, seen := i.state.Devices[mac]
+		if !seen {
+			device = inventoryDevice{FirstSeen: now, Learned: learn}
+			changed = true
+		}
+		if now.Sub(device.LastSeen) >= lastSeenResolution {
+			changed = true
+		}
+		device.LastSeen = now
+
+		if !i.isApproved(mac, device) {
+			i.unknownMetric.WithLabelValues(mac).Set(float64(1))
+			if !device.Notified && !i.notifying[mac] {
+				slog.Warn("unknown device connected", slog.String("mac", mac), slog.String("name", client["Name"]), slog.String("ip", client["IPAddress"]))
+				if i.notifier == nil {
+					device.Notified = true
+					changed = true
+				} else {
+					i.notifying[mac] = true
+					i.notify(mac, client, now)
+				}
+			}
+		}
+		i.state.Devices[mac] = device
+	}
+
+	if i.forgetAfter > 0 {
+		for mac, device := range i.state.Devices {
+			if !device.Learned && now.Sub(device.LastSeen) > i.forgetAfter {
+				slog.Debug("forgetting device", slog.String("mac", mac), slog.Time("last_seen", device.LastSeen))
+				delete(i.state.Devices, mac)
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		if err := i.save(); err != nil {
+			slog.Error("failed to save device inventory", slog.String("file", i.stateFile), slog.String("error", err.Error()))
+		}
+	}
+}
+
+/* Must be called with the mutex held */
+func (i *Inventory) isApproved(mac string, device inventoryDevice) bool {
+	if device.Learned || i.approved[mac] {
+		return true
+	}
+	_, found := i.aliases.Lookup(mac)
+	return found
+}
+
+/* The device is only marked notified once a webhook accepted the event, until then every scrape tries again */
+func (i *Inventory) notify(mac string, client map[string]string, now time.Time) {
+	i.notifier.Notify(webhook.Event{
+		Event:          "unknown_device",
+		Message:        fmt.Sprintf("Unknown device '%s' (%s) connected to the network with IP address %s", client["Name"], mac, client["IPAddress"]),
+		MAC:            mac,
+		Name:           client["Name"],
+		IP:             client["IPAddress"],
+		ConnectionType: client["ConnectionType"],
+		Time:           now,
+	}, func(delivered bool) {
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		i.markNotified(mac, delivered)
+	})
+}
+
+/* Must be called with the mutex held */
+func (i *Inventory) markNotified(mac string, delivered bool) {
+	delete(i.notifying, mac)
+	device, found := i.state.Devices[mac]
+	if !delivered || !found {
+		return
+	}
+
+	device.Notified = true
+	i.state.Devices[mac] = device
+	if err := i.save(); err != nil {
+		slog.Error("failed to save device inventory", slog.String("file", i.stateFile), slog.String("error", err.Error()))
+	}
+}
+
+func (i *Inventory) save() error {
+	if i.stateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(i.state)
+	if err != nil {
+		return err
+	}
+
+	/* Write next to the real file and move it over so a crash never leaves half a file behind */
+	tmp, err := os.CreateTemp(filepath.Dir(i.stateFile), filepath.Base(i.stateFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), i.stateFile)
+}
+
+func (i *Inventory) Collect(ch chan<- prometheus.Metric) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.unknownMetric.Collect(ch)
+}
+
+func (i *Inventory) Describe(ch chan<- *prometheus.Desc) {
+	i.unknownMetric.Describe(ch)
+}
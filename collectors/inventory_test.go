@@ -0,0 +1,201 @@
+package collectors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/webhook"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInventoryLearnsFirstClients(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "devices.json")
+	deviceAliases, _ := aliases.New("")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	i, err := NewInventory("netgear", "", deviceAliases, nil, stateFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Observe([]map[string]string{{"MACAddress": "aa:bb:cc:00:00:01"}}, now)
+	if got := testutil.CollectAndCount(i.unknownMetric); got != 0 {
+		t.Errorf("got %d unknown clients on the first inventory, want 0", got)
+	}
+
+	/* A restart must remember the learned client */
+	i, err = NewInventory("netgear", "", deviceAliases, nil, stateFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Observe([]map[string]string{{"MACAddress": "AA:BB:CC:00:00:01"}, {"MACAddress": "AA:BB:CC:00:00:02"}}, now.Add(time.Minute))
+	if got := testutil.ToFloat64(i.unknownMetric.WithLabelValues("AA:BB:CC:00:00:02")); got != 1 {
+		t.Errorf("got %v for the new client, want 1", got)
+	}
+	if got := testutil.CollectAndCount(i.unknownMetric); got != 1 {
+		t.Errorf("got %d unknown clients, want 1", got)
+	}
+	if !i.state.Devices["AA:BB:CC:00:00:02"].Notified {
+		t.Error("the new client was not notified")
+	}
+}
+
+func TestInventoryApprovedList(t *testing.T) {
+	dir := t.TempDir()
+	approvedFile := filepath.Join(dir, "approved.txt")
+	if err := os.WriteFile(approvedFile, []byte("# trusted\nAA-BB-CC-00-00-01\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	aliasFile := filepath.Join(dir, "aliases.csv")
+	if err := os.WriteFile(aliasFile, []byte("AA:BB:CC:00:00:02,Tablet\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deviceAliases, err := aliases.New(aliasFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := NewInventory("netgear", approvedFile, deviceAliases, nil, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Observe([]map[string]string{
+		{"MACAddress": "AA:BB:CC:00:00:01"},
+		{"MACAddress": "AA:BB:CC:00:00:02"},
+		{"MACAddress": "AA:BB:CC:00:00:03"},
+	}, time.Now())
+
+	/* With an approved list nothing is learned, even on the first inventory */
+	if got := testutil.CollectAndCount(i.unknownMetric); got != 1 {
+		t.Errorf("got %d unknown clients, want 1", got)
+	}
+	if got := testutil.ToFloat64(i.unknownMetric.WithLabelValues("AA:BB:CC:00:00:03")); got != 1 {
+		t.Errorf("got %v for the unapproved client, want 1", got)
+	}
+
+	if err := os.WriteFile(approvedFile, []byte("AA:BB:CC:00:00:01\nAA:BB:CC:00:00:03\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	i.Observe([]map[string]string{{"MACAddress": "AA:BB:CC:00:00:03"}}, time.Now())
+	if got := testutil.CollectAndCount(i.unknownMetric); got != 0 {
+		t.Errorf("got %d unknown clients after approving, want 0", got)
+	}
+}
+
+func TestInventoryNullDevices(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "devices.json")
+	if err := os.WriteFile(stateFile, []byte(`{"taken":true,"devices":null}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deviceAliases, _ := aliases.New("")
+
+	i, err := NewInventory("netgear", "", deviceAliases, nil, stateFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Observe([]map[string]string{{"MACAddress": "AA:BB:CC:00:00:01"}}, time.Now())
+	if got := testutil.CollectAndCount(i.unknownMetric); got != 1 {
+		t.Errorf("got %d unknown clients, want 1", got)
+	}
+}
+
+func TestInventoryForgetsDevices(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "devices.json")
+	deviceAliases, _ := aliases.New("")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	i, err := NewInventory("netgear", "", deviceAliases, nil, stateFile, 30*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Observe([]map[string]string{{"MACAddress": "AA:BB:CC:00:00:01"}}, now)
+	i.Observe([]map[string]string{{"MACAddress": "DA:A1:19:00:00:02"}}, now.Add(time.Hour))
+	i.Observe([]map[string]string{{"MACAddress": "DA:A1:19:00:00:03"}}, now.Add(20*24*time.Hour))
+
+	/* The learned client stays, the unknown one that left a month ago goes */
+	i.Observe(nil, now.Add(40*24*time.Hour))
+	for mac, want := range map[string]bool{"AA:BB:CC:00:00:01": true, "DA:A1:19:00:00:02": false, "DA:A1:19:00:00:03": true} {
+		if _, got := i.state.Devices[mac]; got != want {
+			t.Errorf("got %v for %s in the inventory, want %v", got, mac, want)
+		}
+	}
+
+	/* The state file is rid of it as well */
+	i, err = NewInventory("netgear", "", deviceAliases, nil, stateFile, 30*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := i.state.Devices["DA:A1:19:00:00:02"]; found || len(i.state.Devices) != 2 {
+		t.Errorf("got %v from the state file", i.state.Devices)
+	}
+}
+
+/* Wait for the notifications under way to finish */
+func (i *Inventory) waitNotified(t *testing.T) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		i.mutex.Lock()
+		pending := len(i.notifying)
+		i.mutex.Unlock()
+		if pending == 0 {
+			return
+		}
+	}
+	t.Fatal("notifications did not finish")
+}
+
+func TestInventoryRetriesNotification(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	stateFile := filepath.Join(t.TempDir(), "devices.json")
+	deviceAliases, _ := aliases.New("")
+	notifier := webhook.New([]webhook.Target{{URL: server.URL}}, 0)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	i, err := NewInventory("netgear", "", deviceAliases, notifier, stateFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Observe(nil, now)
+
+	/* The webhook is down, so the client is not notified yet */
+	clients := []map[string]string{{"MACAddress": "AA:BB:CC:00:00:02"}}
+	i.Observe(clients, now.Add(time.Minute))
+	i.waitNotified(t)
+	if i.state.Devices["AA:BB:CC:00:00:02"].Notified {
+		t.Error("the client was marked notified without a delivery")
+	}
+
+	/* The next scrape tries again */
+	status.Store(http.StatusOK)
+	i.Observe(clients, now.Add(2*time.Minute))
+	i.waitNotified(t)
+	i.Observe(clients, now.Add(3*time.Minute))
+	i.waitNotified(t)
+	if got := calls.Load(); got != 2 {
+		t.Errorf("got %d webhook calls, want 2", got)
+	}
+
+	/* The delivery is saved */
+	i, err = NewInventory("netgear", "", deviceAliases, notifier, stateFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !i.state.Devices["AA:BB:CC:00:00:02"].Notified {
+		t.Error("the delivered notification was not saved")
+	}
+}
@@ -0,0 +1,215 @@
+package collectors
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/soap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type PortMappingCollector struct {
+	namespace                 string
+	client                    *soap.Client
+	upnpClient                *soap.UPnPClient
+	portForwardingMetric      *prometheus.GaugeVec
+	portForwardingRulesMetric prometheus.Gauge
+	upnpMappingMetric         *prometheus.GaugeVec
+	upnpMappingsMetric        prometheus.Gauge
+	upnpUnsupportedOnce       sync.Once
+
+	scrapesTotalMetric              prometheus.Counter
+	scrapeErrorsTotalMetric         prometheus.Counter
+	lastScrapeErrorMetric           prometheus.Gauge
+	lastScrapeTimestampMetric       prometheus.Gauge
+	lastScrapeDurationSecondsMetric prometheus.Gauge
+}
+
+var (
+	portForwardingLabels = []string{"protocol", "external_port", "internal_ip", "internal_port", "description"}
+	upnpMappingLabels    = []string{"protocol", "external_port", "internal_ip", "internal_port", "description", "enabled"}
+)
+
+func NewPortMappingCollector(namespace string, client *soap.Client, upnpClient *soap.UPnPClient) *PortMappingCollector {
+	portForwardingMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "port_forwarding",
+			Name:      "info",
+			Help:      "Static port forwarding rule with protocol, external port, internal IP and port and description labels",
+		},
+		portForwardingLabels,
+	)
+
+	portForwardingRulesMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "port_forwarding_rules",
+			Help:      "Number of static port forwarding rules configured on the router.",
+		},
+	)
+
+	upnpMappingMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "upnp_mapping",
+			Name:      "info",
+			Help:      "UPnP port mapping with protocol, external port, internal IP and port, description and enabled labels",
+		},
+		upnpMappingLabels,
+	)
+
+	upnpMappingsMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "upnp_mappings",
+			Help:      "Number of port mappings created on the router through UPnP.",
+		},
+	)
+
+	scrapesTotalMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "port_mapping_scrapes",
+			Name:      "total",
+			Help:      "Total number of scrapes for Netgear port mappings.",
+		},
+	)
+
+	scrapeErrorsTotalMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "port_mapping_scrape_errors",
+			Name:      "total",
+			Help:      "Total number of scrapes errors for Netgear port mappings.",
+		},
+	)
+
+	lastScrapeErrorMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_port_mapping_scrape_error",
+			Help:      "Whether the last scrape of Netgear port mappings resulted in an error (1 for error, 0 for success).",
+		},
+	)
+
+	lastScrapeTimestampMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_port_mapping_scrape_timestamp",
+			Help:      "Number of seconds since 1970 since last scrape of Netgear port mappings.",
+		},
+	)
+
+	lastScrapeDurationSecondsMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_port_mapping_scrape_duration_seconds",
+			Help:      "Duration of the last scrape of Netgear port mappings.",
+		},
+	)
+
+	return &PortMappingCollector{
+		namespace:                 namespace,
+		client:                    client,
+		upnpClient:                upnpClient,
+		portForwardingMetric:      portForwardingMetric,
+		portForwardingRulesMetric: portForwardingRulesMetric,
+		upnpMappingMetric:         upnpMappingMetric,
+		upnpMappingsMetric:        upnpMappingsMetric,
+
+		scrapesTotalMetric:              scrapesTotalMetric,
+		scrapeErrorsTotalMetric:         scrapeErrorsTotalMetric,
+		lastScrapeErrorMetric:           lastScrapeErrorMetric,
+		lastScrapeTimestampMetric:       lastScrapeTimestampMetric,
+		lastScrapeDurationSecondsMetric: lastScrapeDurationSecondsMetric,
+	}
+}
+
+func (c *PortMappingCollector) Collect(ch chan<- prometheus.Metric) {
+	var begun = time.Now()
+	requestID := newRequestID()
+
+	errorMetric := float64(0)
+
+	/* Static rules need a SOAP action that not every firmware implements - skip them quietly on the rest */
+	rules, err := c.client.GetPortMappingInfo()
+	var respErr *soap.ResponseError
+	if errors.As(err, &respErr) && respErr.Unsupported() {
+		slog.Debug("router does not list port forwarding rules", slog.String("error", err.Error()))
+	} else if err != nil {
+		slog.Error("error while collecting port forwarding rules", slog.String("request_id", requestID), slog.String("error", err.Error()))
+		errorMetric = float64(1)
+	} else {
+		setPortMappings(c.portForwardingMetric, rules, false)
+		c.portForwardingMetric.Collect(ch)
+
+		c.portForwardingRulesMetric.Set(float64(len(rules)))
+		c.portForwardingRulesMetric.Collect(ch)
+	}
+
+	mappings, err := c.upnpClient.GetGenericPortMappingEntries()
+	if errors.Is(err, soap.ErrUPnPUnsupported) {
+		c.upnpUnsupportedOnce.Do(func() {
+			slog.Info("router does not serve UPnP, skipping UPnP port mappings", slog.String("error", err.Error()))
+		})
+	} else if err != nil {
+		slog.Error("error while collecting UPnP port mappings", slog.String("request_id", requestID), slog.String("error", err.Error()))
+		errorMetric = float64(1)
+	} else {
+		setPortMappings(c.upnpMappingMetric, mappings, true)
+		c.upnpMappingMetric.Collect(ch)
+
+		c.upnpMappingsMetric.Set(float64(len(mappings)))
+		c.upnpMappingsMetric.Collect(ch)
+	}
+
+	if errorMetric != 0 {
+		countScrapeError(c.scrapeErrorsTotalMetric, requestID)
+	}
+	c.scrapeErrorsTotalMetric.Collect(ch)
+
+	c.scrapesTotalMetric.Inc()
+	c.scrapesTotalMetric.Collect(ch)
+
+	c.lastScrapeErrorMetric.Set(errorMetric)
+	c.lastScrapeErrorMetric.Collect(ch)
+
+	c.lastScrapeTimestampMetric.Set(float64(time.Now().Unix()))
+	c.lastScrapeTimestampMetric.Collect(ch)
+
+	c.lastScrapeDurationSecondsMetric.Set(time.Since(begun).Seconds())
+	c.lastScrapeDurationSecondsMetric.Collect(ch)
+}
+
+/* Mappings come and go - drop the ones from the previous scrape so they do not linger. Only UPnP mappings tell whether they are enabled */
+func setPortMappings(metric *prometheus.GaugeVec, mappings []soap.PortMapping, withEnabled bool) {
+	metric.Reset()
+	for _, m := range mappings {
+		labels := []string{m.Protocol, m.ExternalPort, m.InternalClient, m.InternalPort, m.Description}
+		if withEnabled {
+			labels = append(labels, strconv.FormatBool(m.Enabled))
+		}
+		metric.WithLabelValues(labels...).Set(float64(1))
+	}
+}
+
+func (c *PortMappingCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.portForwardingMetric.Describe(ch)
+	c.portForwardingRulesMetric.Describe(ch)
+	c.upnpMappingMetric.Describe(ch)
+	c.upnpMappingsMetric.Describe(ch)
+	c.scrapesTotalMetric.Describe(ch)
+	c.scrapeErrorsTotalMetric.Describe(ch)
+	c.lastScrapeErrorMetric.Describe(ch)
+	c.lastScrapeTimestampMetric.Describe(ch)
+	c.lastScrapeDurationSecondsMetric.Describe(ch)
+}
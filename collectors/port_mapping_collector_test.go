@@ -0,0 +1,119 @@
+package collectors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DRuggeri/netgear_exporter/soap"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPortMappingCollector(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{
+		"WANIPConnection:1#GetPortMappingInfo": {fields: map[string]string{"PortMappingInfo": "NAS;tcp;443;8443;192.168.1.10@Game;udp;3074;3074;192.168.1.20@"}},
+	})
+	router.setMappings([]soap.PortMapping{
+		{Description: "Plex", Protocol: "TCP", ExternalPort: "32400", InternalClient: "192.168.1.10", InternalPort: "32400", Enabled: true},
+		{Description: "Xbox", Protocol: "UDP", ExternalPort: "3075", InternalClient: "192.168.1.20", InternalPort: "3075", Enabled: false},
+	})
+	c := NewPortMappingCollector("netgear", router.soapClient(t), router.upnpClient())
+
+	want := `
+# HELP netgear_port_forwarding_info Static port forwarding rule with protocol, external port, internal IP and port and description labels
+# TYPE netgear_port_forwarding_info gauge
+netgear_port_forwarding_info{description="Game",external_port="3074",internal_ip="192.168.1.20",internal_port="3074",protocol="UDP"} 1
+netgear_port_forwarding_info{description="NAS",external_port="443",internal_ip="192.168.1.10",internal_port="8443",protocol="TCP"} 1
+# HELP netgear_upnp_mapping_info UPnP port mapping with protocol, external port, internal IP and port, description and enabled labels
+# TYPE netgear_upnp_mapping_info gauge
+netgear_upnp_mapping_info{description="Plex",enabled="true",external_port="32400",internal_ip="192.168.1.10",internal_port="32400",protocol="TCP"} 1
+netgear_upnp_mapping_info{description="Xbox",enabled="false",external_port="3075",internal_ip="192.168.1.20",internal_port="3075",protocol="UDP"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "netgear_port_forwarding_info", "netgear_upnp_mapping_info"); err != nil {
+		t.Error(err)
+	}
+
+	/* A mapping that went away must not linger from the previous scrape */
+	router.set("WANIPConnection:1#GetPortMappingInfo", fakeAction{fields: map[string]string{"PortMappingInfo": "NAS;tcp;443;8443;192.168.1.10@"}})
+	router.setMappings(router.mappings[:1])
+	want = `
+# HELP netgear_port_forwarding_info Static port forwarding rule with protocol, external port, internal IP and port and description labels
+# TYPE netgear_port_forwarding_info gauge
+netgear_port_forwarding_info{description="NAS",external_port="443",internal_ip="192.168.1.10",internal_port="8443",protocol="TCP"} 1
+# HELP netgear_port_forwarding_rules Number of static port forwarding rules configured on the router.
+# TYPE netgear_port_forwarding_rules gauge
+netgear_port_forwarding_rules 1
+# HELP netgear_upnp_mapping_info UPnP port mapping with protocol, external port, internal IP and port, description and enabled labels
+# TYPE netgear_upnp_mapping_info gauge
+netgear_upnp_mapping_info{description="Plex",enabled="true",external_port="32400",internal_ip="192.168.1.10",internal_port="32400",protocol="TCP"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "netgear_port_forwarding_info", "netgear_port_forwarding_rules", "netgear_upnp_mapping_info"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPortMappingCollectorUnsupported(t *testing.T) {
+	for _, code := range []string{"404", "501"} {
+		router := newFakeRouter(t, map[string]fakeAction{"WANIPConnection:1#GetPortMappingInfo": {code: code}})
+		c := NewPortMappingCollector("netgear", router.soapClient(t), router.upnpClient())
+
+		/* Firmware that cannot list its forwarding rules still has the UPnP mappings */
+		want := `
+# HELP netgear_last_port_mapping_scrape_error Whether the last scrape of Netgear port mappings resulted in an error (1 for error, 0 for success).
+# TYPE netgear_last_port_mapping_scrape_error gauge
+netgear_last_port_mapping_scrape_error 0
+# HELP netgear_upnp_mappings Number of port mappings created on the router through UPnP.
+# TYPE netgear_upnp_mappings gauge
+netgear_upnp_mappings 0
+`
+		if err := testutil.CollectAndCompare(c, strings.NewReader(want), "netgear_last_port_mapping_scrape_error", "netgear_port_forwarding_rules", "netgear_upnp_mappings"); err != nil {
+			t.Errorf("response code %s: %v", code, err)
+		}
+	}
+}
+
+func TestPortMappingCollectorErrors(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{"WANIPConnection:1#GetPortMappingInfo": {code: "002"}})
+	c := NewPortMappingCollector("netgear", router.soapClient(t), soap.NewUPnPClient("http://127.0.0.1:1/upnp", 1))
+
+	want := `
+# HELP netgear_last_port_mapping_scrape_error Whether the last scrape of Netgear port mappings resulted in an error (1 for error, 0 for success).
+# TYPE netgear_last_port_mapping_scrape_error gauge
+netgear_last_port_mapping_scrape_error 1
+# HELP netgear_port_mapping_scrape_errors_total Total number of scrapes errors for Netgear port mappings.
+# TYPE netgear_port_mapping_scrape_errors_total counter
+netgear_port_mapping_scrape_errors_total 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "netgear_last_port_mapping_scrape_error", "netgear_port_mapping_scrape_errors_total", "netgear_port_forwarding_info", "netgear_upnp_mapping_info"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPortMappingCollectorUPnPUnsupported(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{"WANIPConnection:1#GetPortMappingInfo": {fields: map[string]string{"PortMappingInfo": ""}}})
+
+	/* With UPnP turned off nothing listens on its port */
+	c := NewPortMappingCollector("netgear", router.soapClient(t), soap.NewUPnPClient("http://127.0.0.1:1/upnp", 1))
+	want := `
+# HELP netgear_last_port_mapping_scrape_error Whether the last scrape of Netgear port mappings resulted in an error (1 for error, 0 for success).
+# TYPE netgear_last_port_mapping_scrape_error gauge
+netgear_last_port_mapping_scrape_error 0
+# HELP netgear_port_forwarding_rules Number of static port forwarding rules configured on the router.
+# TYPE netgear_port_forwarding_rules gauge
+netgear_port_forwarding_rules 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "netgear_last_port_mapping_scrape_error", "netgear_port_forwarding_rules", "netgear_upnp_mappings"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPortMappingCollectorInvalidRule(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{"WANIPConnection:1#GetPortMappingInfo": {fields: map[string]string{"PortMappingInfo": "NAS;tcp;443@"}}})
+	c := NewPortMappingCollector("netgear", router.soapClient(t), router.upnpClient())
+
+	if got := testutil.CollectAndCount(c, "netgear_port_forwarding_rules"); got != 0 {
+		t.Errorf("got %d rule counts for an invalid rule, want 0", got)
+	}
+	if got := testutil.ToFloat64(c.lastScrapeErrorMetric); got != 1 {
+		t.Errorf("got %v for the last scrape error, want 1", got)
+	}
+}
@@ -0,0 +1,240 @@
+package collectors
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/soap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/* Netgear reports QoS bandwidths in Mbps */
+const mbpsToBytesPerSecond = float64(1000000) / float64(8)
+
+type QoSCollector struct {
+	namespace               string
+	client                  *soap.Client
+	enabledMetric           prometheus.Gauge
+	uplinkBandwidthMetric   prometheus.Gauge
+	downlinkBandwidthMetric prometheus.Gauge
+	speedtestUplinkMetric   prometheus.Gauge
+	speedtestDownlinkMetric prometheus.Gauge
+
+	scrapesTotalMetric              prometheus.Counter
+	scrapeErrorsTotalMetric         prometheus.Counter
+	lastScrapeErrorMetric           prometheus.Gauge
+	lastScrapeTimestampMetric       prometheus.Gauge
+	lastScrapeDurationSecondsMetric prometheus.Gauge
+}
+
+func NewQoSCollector(namespace string, client *soap.Client) *QoSCollector {
+	enabledMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "qos",
+			Name:      "enabled",
+			Help:      "Whether QoS is enabled on the router (1 for enabled, 0 for disabled).",
+		},
+	)
+
+	uplinkBandwidthMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "qos",
+			Name:      "uplink_bandwidth_bytes_per_second",
+			Help:      "Uplink bandwidth configured for QoS.",
+		},
+	)
+
+	downlinkBandwidthMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "qos",
+			Name:      "downlink_bandwidth_bytes_per_second",
+			Help:      "Downlink bandwidth configured for QoS.",
+		},
+	)
+
+	speedtestUplinkMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "qos",
+			Name:      "speedtest_uplink_bytes_per_second",
+			Help:      "Uplink bandwidth measured by the last speed test of the router.",
+		},
+	)
+
+	speedtestDownlinkMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "qos",
+			Name:      "speedtest_downlink_bytes_per_second",
+			Help:      "Downlink bandwidth measured by the last speed test of the router.",
+		},
+	)
+
+	scrapesTotalMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "qos_scrapes",
+			Name:      "total",
+			Help:      "Total number of scrapes for Netgear QoS stats.",
+		},
+	)
+
+	scrapeErrorsTotalMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "qos_scrape_errors",
+			Name:      "total",
+			Help:      "Total number of scrapes errors for Netgear QoS stats.",
+		},
+	)
+
+	lastScrapeErrorMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_qos_scrape_error",
+			Help:      "Whether the last scrape of Netgear QoS stats resulted in an error (1 for error, 0 for success).",
+		},
+	)
+
+	lastScrapeTimestampMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_qos_scrape_timestamp",
+			Help:      "Number of seconds since 1970 since last scrape of Netgear QoS metrics.",
+		},
+	)
+
+	lastScrapeDurationSecondsMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "",
+			Name:      "last_qos_scrape_duration_seconds",
+			Help:      "Duration of the last scrape of Netgear QoS stats.",
+		},
+	)
+
+	return &QoSCollector{
+		namespace:               namespace,
+		client:                  client,
+		enabledMetric:           enabledMetric,
+		uplinkBandwidthMetric:   uplinkBandwidthMetric,
+		downlinkBandwidthMetric: downlinkBandwidthMetric,
+		speedtestUplinkMetric:   speedtestUplinkMetric,
+		speedtestDownlinkMetric: speedtestDownlinkMetric,
+
+		scrapesTotalMetric:              scrapesTotalMetric,
+		scrapeErrorsTotalMetric:         scrapeErrorsTotalMetric,
+		lastScrapeErrorMetric:           lastScrapeErrorMetric,
+		lastScrapeTimestampMetric:       lastScrapeTimestampMetric,
+		lastScrapeDurationSecondsMetric: lastScrapeDurationSecondsMetric,
+	}
+}
+
+func (c *QoSCollector) Collect(ch chan<- prometheus.Metric) {
+	var begun = time.Now()
+	requestID := newRequestID()
+
+	errorMetric := float64(0)
+	if err := c.collectSettings(ch); err != nil {
+		slog.Error("error while collecting QoS settings", slog.String("request_id", requestID), slog.String("error", err.Error()))
+		errorMetric = float64(1)
+		countScrapeError(c.scrapeErrorsTotalMetric, requestID)
+	}
+
+	c.scrapeErrorsTotalMetric.Collect(ch)
+
+	c.scrapesTotalMetric.Inc()
+	c.scrapesTotalMetric.Collect(ch)
+
+	c.lastScrapeErrorMetric.Set(errorMetric)
+	c.lastScrapeErrorMetric.Collect(ch)
+
+	c.lastScrapeTimestampMetric.Set(float64(time.Now().Unix()))
+	c.lastScrapeTimestampMetric.Collect(ch)
+
+	c.lastScrapeDurationSecondsMetric.Set(time.Since(begun).Seconds())
+	c.lastScrapeDurationSecondsMetric.Collect(ch)
+}
+
+func (c *QoSCollector) collectSettings(ch chan<- prometheus.Metric) error {
+	status, err := c.client.GetQoSEnableStatus()
+	if err != nil {
+		return err
+	}
+	enabled, err := parseQoSValue(status, "QoSEnableStatus", 1)
+	if err != nil {
+		return err
+	}
+	c.enabledMetric.Set(enabled)
+	c.enabledMetric.Collect(ch)
+
+	options, err := c.client.GetBandwidthControlOptions()
+	if err != nil {
+		return err
+	}
+	uplink, err := parseQoSValue(options, "UplinkBandwidth", mbpsToBytesPerSecond)
+	if err != nil {
+		return err
+	}
+	downlink, err := parseQoSValue(options, "DownlinkBandwidth", mbpsToBytesPerSecond)
+	if err != nil {
+		return err
+	}
+	c.uplinkBandwidthMetric.Set(uplink)
+	c.uplinkBandwidthMetric.Collect(ch)
+	c.downlinkBandwidthMetric.Set(downlink)
+	c.downlinkBandwidthMetric.Collect(ch)
+
+	/* Only firmware with Dynamic QoS keeps a speed test result - skip it quietly on the rest */
+	speedtest, err := c.client.GetOOKLASpeedTestResult()
+	var respErr *soap.ResponseError
+	if errors.As(err, &respErr) && respErr.Unsupported() {
+		slog.Debug("router does not keep a speed test result", slog.String("error", err.Error()))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if val, err := parseQoSValue(speedtest, "OOKLAUplinkBandwidth", mbpsToBytesPerSecond); err == nil {
+		c.speedtestUplinkMetric.Set(val)
+		c.speedtestUplinkMetric.Collect(ch)
+	}
+	if val, err := parseQoSValue(speedtest, "OOKLADownlinkBandwidth", mbpsToBytesPerSecond); err == nil {
+		c.speedtestDownlinkMetric.Set(val)
+		c.speedtestDownlinkMetric.Collect(ch)
+	}
+	return nil
+}
+
+func parseQoSValue(fields map[string]string, name string, multiplier float64) (float64, error) {
+	val, ok := fields[name]
+	if !ok {
+		return 0, fmt.Errorf("QoS field '%s' missing from results", name)
+	}
+	metric, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse QoS field '%s' value '%s': %v", name, val, err)
+	}
+	return metric * multiplier, nil
+}
+
+func (c *QoSCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.enabledMetric.Describe(ch)
+	c.uplinkBandwidthMetric.Describe(ch)
+	c.downlinkBandwidthMetric.Describe(ch)
+	c.speedtestUplinkMetric.Describe(ch)
+	c.speedtestDownlinkMetric.Describe(ch)
+	c.scrapesTotalMetric.Describe(ch)
+	c.scrapeErrorsTotalMetric.Describe(ch)
+	c.lastScrapeErrorMetric.Describe(ch)
+	c.lastScrapeTimestampMetric.Describe(ch)
+	c.lastScrapeDurationSecondsMetric.Describe(ch)
+}
@@ -0,0 +1,119 @@
+package collectors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var qosMetrics = []string{
+	"netgear_qos_enabled",
+	"netgear_qos_uplink_bandwidth_bytes_per_second",
+	"netgear_qos_downlink_bandwidth_bytes_per_second",
+	"netgear_qos_speedtest_uplink_bytes_per_second",
+	"netgear_qos_speedtest_downlink_bytes_per_second",
+	"netgear_last_qos_scrape_error",
+}
+
+func newQoSRouter(t *testing.T) *fakeRouter {
+	return newFakeRouter(t, map[string]fakeAction{
+		"DeviceConfig:1#GetQoSEnableStatus":        {fields: map[string]string{"QoSEnableStatus": "1"}},
+		"AdvancedQOS:1#GetBandwidthControlOptions": {fields: map[string]string{"UplinkBandwidth": "20.00", "DownlinkBandwidth": "100.00"}},
+		"AdvancedQOS:1#GetOOKLASpeedTestResult":    {fields: map[string]string{"OOKLAUplinkBandwidth": "18.5", "OOKLADownlinkBandwidth": "94"}},
+	})
+}
+
+func TestQoSCollector(t *testing.T) {
+	router := newQoSRouter(t)
+	c := NewQoSCollector("netgear", router.soapClient(t))
+
+	want := `
+# HELP netgear_last_qos_scrape_error Whether the last scrape of Netgear QoS stats resulted in an error (1 for error, 0 for success).
+# TYPE netgear_last_qos_scrape_error gauge
+netgear_last_qos_scrape_error 0
+# HELP netgear_qos_downlink_bandwidth_bytes_per_second Downlink bandwidth configured for QoS.
+# TYPE netgear_qos_downlink_bandwidth_bytes_per_second gauge
+netgear_qos_downlink_bandwidth_bytes_per_second 1.25e+07
+# HELP netgear_qos_enabled Whether QoS is enabled on the router (1 for enabled, 0 for disabled).
+# TYPE netgear_qos_enabled gauge
+netgear_qos_enabled 1
+# HELP netgear_qos_speedtest_downlink_bytes_per_second Downlink bandwidth measured by the last speed test of the router.
+# TYPE netgear_qos_speedtest_downlink_bytes_per_second gauge
+netgear_qos_speedtest_downlink_bytes_per_second 1.175e+07
+# HELP netgear_qos_speedtest_uplink_bytes_per_second Uplink bandwidth measured by the last speed test of the router.
+# TYPE netgear_qos_speedtest_uplink_bytes_per_second gauge
+netgear_qos_speedtest_uplink_bytes_per_second 2.3125e+06
+# HELP netgear_qos_uplink_bandwidth_bytes_per_second Uplink bandwidth configured for QoS.
+# TYPE netgear_qos_uplink_bandwidth_bytes_per_second gauge
+netgear_qos_uplink_bandwidth_bytes_per_second 2.5e+06
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), qosMetrics...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQoSCollectorUnsupported(t *testing.T) {
+	for _, code := range []string{"404", "501"} {
+		router := newQoSRouter(t)
+		router.set("AdvancedQOS:1#GetOOKLASpeedTestResult", fakeAction{code: code})
+		c := NewQoSCollector("netgear", router.soapClient(t))
+
+		/* Firmware without Dynamic QoS has no speed test - that is not a scrape error */
+		want := `
+# HELP netgear_last_qos_scrape_error Whether the last scrape of Netgear QoS stats resulted in an error (1 for error, 0 for success).
+# TYPE netgear_last_qos_scrape_error gauge
+netgear_last_qos_scrape_error 0
+# HELP netgear_qos_enabled Whether QoS is enabled on the router (1 for enabled, 0 for disabled).
+# TYPE netgear_qos_enabled gauge
+netgear_qos_enabled 1
+`
+		if err := testutil.CollectAndCompare(c, strings.NewReader(want), "netgear_qos_enabled", "netgear_qos_speedtest_downlink_bytes_per_second", "netgear_last_qos_scrape_error"); err != nil {
+			t.Errorf("response code %s: %v", code, err)
+		}
+	}
+}
+
+func TestQoSCollectorErrors(t *testing.T) {
+	for _, test := range []struct {
+		action string
+		answer fakeAction
+	}{
+		{"DeviceConfig:1#GetQoSEnableStatus", fakeAction{code: "501"}},
+		{"AdvancedQOS:1#GetBandwidthControlOptions", fakeAction{code: "002"}},
+		{"AdvancedQOS:1#GetBandwidthControlOptions", fakeAction{fields: map[string]string{"UplinkBandwidth": "20.00"}}},
+		{"AdvancedQOS:1#GetOOKLASpeedTestResult", fakeAction{code: "002"}},
+	} {
+		router := newQoSRouter(t)
+		router.set(test.action, test.answer)
+		c := NewQoSCollector("netgear", router.soapClient(t))
+
+		want := `
+# HELP netgear_last_qos_scrape_error Whether the last scrape of Netgear QoS stats resulted in an error (1 for error, 0 for success).
+# TYPE netgear_last_qos_scrape_error gauge
+netgear_last_qos_scrape_error 1
+# HELP netgear_qos_scrape_errors_total Total number of scrapes errors for Netgear QoS stats.
+# TYPE netgear_qos_scrape_errors_total counter
+netgear_qos_scrape_errors_total 1
+`
+		if err := testutil.CollectAndCompare(c, strings.NewReader(want), "netgear_last_qos_scrape_error", "netgear_qos_scrape_errors_total"); err != nil {
+			t.Errorf("%s %+v: %v", test.action, test.answer, err)
+		}
+	}
+}
+
+func TestQoSCollectorActions(t *testing.T) {
+	router := newQoSRouter(t)
+	testutil.CollectAndCount(NewQoSCollector("netgear", router.soapClient(t)))
+
+	/* The services of the R8000 capture shipped with netgear_client - note the capital QOS */
+	for _, action := range []string{
+		"DeviceConfig:1#GetQoSEnableStatus",
+		"AdvancedQOS:1#GetBandwidthControlOptions",
+		"AdvancedQOS:1#GetOOKLASpeedTestResult",
+	} {
+		if got := router.called(action); got != 1 {
+			t.Errorf("called %s %d times", action, got)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package collectors
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Quota tracks the traffic used in an ISP billing cycle. The router's month counters
+// always start over on the 1st, so usage is accumulated from the growth of the daily
+// traffic counters instead and kept in a state file to survive restarts.
+type Quota struct {
+	namespace  string
+	limitBytes float64
+	resetDay   int
+	stateFile  string
+
+	mutex sync.Mutex
+	state quotaState
+	saved time.Time
+
+	usedMetric       prometheus.Gauge
+	limitMetric      prometheus.Gauge
+	projectedMetric  prometheus.Gauge
+	cycleStartMetric prometheus.Gauge
+	cycleEndMetric   prometheus.Gauge
+}
+
+type quotaState struct {
+	CycleStart    time.Time `json:"cycle_start"`
+	UsedBytes     float64   `json:"used_bytes"`
+	LastReading   float64   `json:"last_reading"`
+	LastReadingAt time.Time `json:"last_reading_at"`
+}
+
+/* The state is saved at most this often while the day and cycle stay the same, so the state file is not written on every scrape */
+const quotaSaveInterval = time.Hour
+
+// NewQuota creates the quota tracker. resetDay is the day of the month the billing
+// cycle starts on - in shorter months the cycle starts on their last day instead.
+// The state is loaded from and saved to stateFile unless it is empty.
+func NewQuota(namespace string, limitBytes float64, resetDay int, stateFile string) (*Quota, error) {
+	if resetDay < 1 || resetDay > 31 {
+		return nil, errors.New("the billing cycle reset day must be between 1 and 31")
+	}
+
+	q := &Quota{
+		namespace:  namespace,
+		limitBytes: limitBytes,
+		resetDay:   resetDay,
+		stateFile:  stateFile,
+
+		usedMetric: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "quota",
+				Name:      "used_bytes",
+				Help:      "Traffic used in the current billing cycle.",
+			},
+		),
+		limitMetric: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "quota",
+				Name:      "limit_bytes",
+				Help:      "Traffic allowed in a billing cycle.",
+			},
+		),
+		projectedMetric: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "quota",
+				Name:      "projected_bytes",
+				Help:      "Traffic that will be used by the end of the current billing cycle at the usage rate so far.",
+			},
+		),
+		cycleStartMetric: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "quota",
+				Name:      "cycle_start_timestamp_seconds",
+				Help:      "Number of seconds since 1970 when the current billing cycle started.",
+			},
+		),
+		cycleEndMetric: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: "quota",
+				Name:      "cycle_end_timestamp_seconds",
+				Help:      "Number of seconds since 1970 when the current billing cycle ends.",
+			},
+		),
+	}
+
+	if stateFile != "" {
+		data, err := os.ReadFile(stateFile)
+		if err == nil {
+			if err := json.Unmarshal(data, &q.state); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// Observe records the traffic counters of the router (download and upload) read at now.
+// Readings without the Today, Yesterday and Month counters are skipped.
+func (q *Quota) Observe(stats map[string]float64, now time.Time) {
+	today, todayOk := trafficTotal(stats, "Today")
+	yesterday, yesterdayOk := trafficTotal(stats, "Yesterday")
+	month, monthOk := trafficTotal(stats, "Month")
+	if !todayOk || !yesterdayOk || !monthOk {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.rollOver(now)
+	last := q.state.LastReadingAt
+	start, _ := billingCycle(now, q.resetDay)
+
+	switch {
+	case last.IsZero():
+		q.state.UsedBytes = q.seed(start, now, today, yesterday, month)
+	case sameDay(last, now):
+		delta := today - q.state.LastReading
+		/* The router restarted and its counters with it */
+		if delta < 0 {
+			delta = today
+		}
+		q.state.UsedBytes += delta
+	case sameDay(last, now.AddDate(0, 0, -1)):
+		/* Yesterday's counter holds what was counted before midnight after the last reading */
+		q.state.UsedBytes += max(yesterday-q.state.LastReading, 0) + today
+	default:
+		/* The days between the last reading and yesterday were missed */
+		q.state.UsedBytes += yesterday + today
+	}
+	q.state.LastReading = today
+	q.state.LastReadingAt = now
+
+	if !last.IsZero() && sameDay(last, now) && now.Sub(q.saved) < quotaSaveInterval {
+		return
+	}
+	if err := q.save(); err != nil {
+		slog.Error("failed to save quota state", slog.String("file", q.stateFile), slog.String("error", err.Error()))
+		return
+	}
+	q.saved = now
+}
+
+/* The traffic used since the cycle started as far as the counters tell - the month counters when it starts on the 1st like them, otherwise only today and yesterday */
+func (q *Quota) seed(start time.Time, now time.Time, today float64, yesterday float64, month float64) float64 {
+	if q.resetDay == 1 {
+		return month
+	}
+	if sameDay(start, now) {
+		return today
+	}
+	if !sameDay(start, now.AddDate(0, 0, -1)) {
+		slog.Info("quota tracking starts mid cycle, only counting the traffic since yesterday", slog.Time("cycle_start", start))
+	}
+	return today + yesterday
+}
+
+/* Start a new cycle when the current one is over. Must be called with the mutex held */
+func (q *Quota) rollOver(now time.Time) {
+	start, _ := billingCycle(now, q.resetDay)
+	if !q.state.CycleStart.Equal(start) {
+		if !q.state.CycleStart.IsZero() {
+			slog.Info("starting a new billing cycle", slog.Time("start", start), slog.Float64("previous_used_bytes", q.state.UsedBytes))
+		}
+		q.state.CycleStart = start
+		q.state.UsedBytes = 0
+		/* The last reading belongs to the previous cycle, the next one seeds the new cycle */
+		q.state.LastReading = 0
+		q.state.LastReadingAt = time.Time{}
+	}
+}
+
+func (q *Quota) save() error {
+	if q.stateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(q.state)
+	if err != nil {
+		return err
+	}
+
+	/* Write next to the real file and move it over so a crash never leaves half a file behind */
+	tmp, err := os.CreateTemp(filepath.Dir(q.stateFile), filepath.Base(q.stateFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.stateFile)
+}
+
+func (q *Quota) Collect(ch chan<- prometheus.Metric) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	q.rollOver(now)
+	start, end := billingCycle(now, q.resetDay)
+
+	q.usedMetric.Set(q.state.UsedBytes)
+	q.usedMetric.Collect(ch)
+
+	q.limitMetric.Set(q.limitBytes)
+	q.limitMetric.Collect(ch)
+
+	q.projectedMetric.Set(projectUsage(q.state.UsedBytes, start, end, now))
+	q.projectedMetric.Collect(ch)
+
+	q.cycleStartMetric.Set(float64(start.Unix()))
+	q.cycleStartMetric.Collect(ch)
+
+	q.cycleEndMetric.Set(float64(end.Unix()))
+	q.cycleEndMetric.Collect(ch)
+}
+
+func (q *Quota) Describe(ch chan<- *prometheus.Desc) {
+	q.usedMetric.Describe(ch)
+	q.limitMetric.Describe(ch)
+	q.projectedMetric.Describe(ch)
+	q.cycleStartMetric.Describe(ch)
+	q.cycleEndMetric.Describe(ch)
+}
+
+// billingCycle returns the start and end of the billing cycle now is in
+func billingCycle(now time.Time, resetDay int) (time.Time, time.Time) {
+	start := resetDate(now.Year(), now.Month(), resetDay, now.Location())
+	if now.Before(start) {
+		start = resetDate(now.Year(), now.Month()-1, resetDay, now.Location())
+	}
+	end := resetDate(start.Year(), start.Month()+1, resetDay, now.Location())
+	return start, end
+}
+
+/* The reset day of a month, or its last day when the month is too short */
+func resetDate(year int, month time.Month, resetDay int, loc *time.Location) time.Time {
+	firstOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	if resetDay > lastDay {
+		resetDay = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, resetDay-1)
+}
+
+/* Extrapolate the usage so far linearly to the end of the cycle */
+func projectUsage(used float64, start time.Time, end time.Time, now time.Time) float64 {
+	elapsed := now.Sub(start).Seconds()
+	if elapsed <= 0 {
+		return used
+	}
+	return used * end.Sub(start).Seconds() / elapsed
+}
+
+/* Download and upload of a traffic period like "Today" */
+func trafficTotal(stats map[string]float64, period string) (float64, bool) {
+	download, downloadOk := stats[period+"Download"]
+	upload, uploadOk := stats[period+"Upload"]
+	return download + upload, downloadOk && uploadOk
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	ay, am, ad := a.Local().Date()
+	by, bm, bd := b.Local().Date()
+	return ay == by && am == bm && ad == bd
+}
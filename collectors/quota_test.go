@@ -0,0 +1,114 @@
+package collectors
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBillingCycle(t *testing.T) {
+	for _, test := range []struct {
+		now      string
+		resetDay int
+		start    string
+		end      string
+	}{
+		{"2026-03-20", 17, "2026-03-17", "2026-04-17"},
+		{"2026-03-16", 17, "2026-02-17", "2026-03-17"},
+		{"2026-03-17", 17, "2026-03-17", "2026-04-17"},
+		{"2026-01-05", 17, "2025-12-17", "2026-01-17"},
+		{"2026-02-28", 31, "2026-02-28", "2026-03-31"},
+		{"2026-02-27", 31, "2026-01-31", "2026-02-28"},
+		{"2026-04-30", 31, "2026-04-30", "2026-05-31"},
+	} {
+		now, _ := time.Parse(time.DateOnly, test.now)
+		now = now.Add(12 * time.Hour)
+		start, end := billingCycle(now, test.resetDay)
+		if start.Format(time.DateOnly) != test.start || end.Format(time.DateOnly) != test.end {
+			t.Errorf("%s with reset day %d: want %s - %s, have %s - %s", test.now, test.resetDay,
+				test.start, test.end, start.Format(time.DateOnly), end.Format(time.DateOnly))
+		}
+	}
+}
+
+/* Router counters with all traffic counted as download */
+func trafficReading(today float64, yesterday float64, month float64) map[string]float64 {
+	return map[string]float64{
+		"TodayDownload": today, "TodayUpload": 0,
+		"YesterdayDownload": yesterday, "YesterdayUpload": 0,
+		"MonthDownload": month, "MonthUpload": 0,
+	}
+}
+
+func TestQuotaObserve(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "quota.json")
+	q, err := NewQuota("netgear", 1000, 17, stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* Starting mid cycle counts today and yesterday */
+	morning := time.Date(2026, 3, 20, 8, 0, 0, 0, time.Local)
+	q.Observe(trafficReading(100, 500, 9000), morning)
+	q.Observe(trafficReading(150, 500, 9050), morning.Add(time.Hour))
+	q.Observe(trafficReading(400, 500, 9300), morning.Add(15*time.Hour))
+	/* Past midnight the daily counter starts over, yesterday's holds the rest of the day */
+	q.Observe(trafficReading(30, 420, 9350), morning.Add(17*time.Hour))
+
+	if q.state.UsedBytes != 950 {
+		t.Errorf("want 950 bytes used, have %v", q.state.UsedBytes)
+	}
+
+	/* A restart picks up where the previous run stopped */
+	q, err = NewQuota("netgear", 1000, 17, stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Observe(trafficReading(80, 420, 9400), morning.Add(18*time.Hour))
+	if q.state.UsedBytes != 1000 {
+		t.Errorf("want 1000 bytes used after a restart, have %v", q.state.UsedBytes)
+	}
+
+	/* Readings within the save interval on the same day are not written */
+	q.Observe(trafficReading(90, 420, 9410), morning.Add(18*time.Hour+10*time.Minute))
+	saved, err := NewQuota("netgear", 1000, 17, stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.state.LastReading != 80 {
+		t.Errorf("want the reading of 80 saved, have %v", saved.state.LastReading)
+	}
+
+	/* A new billing cycle starts with what was counted since midnight */
+	q.Observe(trafficReading(10, 700, 5000), time.Date(2026, 4, 17, 0, 30, 0, 0, time.Local))
+	if q.state.UsedBytes != 10 {
+		t.Errorf("want 10 bytes used in the new cycle, have %v", q.state.UsedBytes)
+	}
+
+	/* A reading without the counters it needs is skipped */
+	q.Observe(map[string]float64{"TodayDownload": 20, "TodayUpload": 0}, time.Date(2026, 4, 17, 1, 0, 0, 0, time.Local))
+	if q.state.UsedBytes != 10 {
+		t.Errorf("want 10 bytes used after an incomplete reading, have %v", q.state.UsedBytes)
+	}
+}
+
+func TestQuotaSeedFromMonth(t *testing.T) {
+	q, err := NewQuota("netgear", 1000, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* The cycle starts on the 1st like the month counters of the router */
+	q.Observe(trafficReading(100, 500, 9000), time.Date(2026, 3, 20, 8, 0, 0, 0, time.Local))
+	if q.state.UsedBytes != 9000 {
+		t.Errorf("want 9000 bytes used, have %v", q.state.UsedBytes)
+	}
+}
+
+func TestProjectUsage(t *testing.T) {
+	start := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	if have := projectUsage(100, start, end, start.AddDate(0, 0, 10)); have != 300 {
+		t.Errorf("want 300, have %v", have)
+	}
+}
@@ -0,0 +1,114 @@
+package collectors
+
+import (
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/filters"
+)
+
+// RandomizedMerger hands the attached clients on to the observers with the clients that
+// connect with a randomized MAC address merged by name. A device that comes back with a
+// new random address keeps the MAC address it was first seen with, so presence tracking
+// follows one device instead of a new one for every address. Clients without a name or
+// with the default name of their model, like "iPhone", are passed on as they are, and so
+// are all clients with their real MAC address.
+type RandomizedMerger struct {
+	observers   []ClientObserver
+	forgetAfter time.Duration
+
+	mutex sync.Mutex
+	names map[string]mergedDevice
+}
+
+type mergedDevice struct {
+	mac      string
+	lastSeen time.Time
+}
+
+/* Default names every device of a model shares, followed by model words like "Galaxy-S21-Ultra" */
+var (
+	genericNames = map[string]bool{
+		"iphone": true, "ipad": true, "ipod": true, "imac": true, "macbook": true,
+		"galaxy": true, "pixel": true, "oneplus": true, "redmi": true, "xiaomi": true,
+		"huawei": true, "honor": true, "oppo": true, "vivo": true, "moto": true, "nokia": true,
+		"unknown": true, "localhost": true,
+	}
+	modelWords = map[string]bool{
+		"pro": true, "max": true, "mini": true, "plus": true, "ultra": true, "air": true,
+		"note": true, "fold": true, "flip": true, "fe": true, "lite": true, "xl": true,
+		"se": true, "tab": true, "watch": true,
+	}
+)
+
+// NewRandomizedMerger creates the merger. A name that was not seen for forgetAfter is
+// forgotten, so the next device with that name is tracked under its own address. 0
+// remembers every name.
+func NewRandomizedMerger(forgetAfter time.Duration, observers ...ClientObserver) *RandomizedMerger {
+	return &RandomizedMerger{observers: observers, forgetAfter: forgetAfter, names: make(map[string]mergedDevice)}
+}
+
+// Observe merges the attached clients (GetAttachDevice fields) and hands them on
+func (m *RandomizedMerger) Observe(clients []map[string]string, now time.Time) {
+	merged := m.merge(clients, now)
+	for _, observer := range m.observers {
+		observer.Observe(merged, now)
+	}
+}
+
+func (m *RandomizedMerger) merge(clients []map[string]string, now time.Time) []map[string]string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	merged := make([]map[string]string, 0, len(clients))
+	attached := make(map[string]bool)
+	for _, client := range clients {
+		name := strings.ToLower(strings.TrimSpace(client["Name"]))
+		if !randomizedMAC(client["MACAddress"]) || name == "" || name == "--" || genericName(name) {
+			merged = append(merged, client)
+			continue
+		}
+
+		device, found := m.names[name]
+		if !found {
+			device.mac = filters.NormalizeMAC(client["MACAddress"])
+		}
+		device.lastSeen = now
+		m.names[name] = device
+
+		/* A device reported with more than one address at a time is still one device */
+		if attached[device.mac] {
+			continue
+		}
+		attached[device.mac] = true
+
+		client = maps.Clone(client)
+		client["MACAddress"] = device.mac
+		merged = append(merged, client)
+	}
+
+	if m.forgetAfter > 0 {
+		for name, device := range m.names {
+			if now.Sub(device.lastSeen) > m.forgetAfter {
+				delete(m.names, name)
+			}
+		}
+	}
+	return merged
+}
+
+/* Whether the lower case name is the default name of a device model, which many devices may share */
+func genericName(name string) bool {
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == ' ' })
+	if len(words) == 0 || !genericNames[words[0]] {
+		return false
+	}
+	for _, word := range words[1:] {
+		if !modelWords[word] && !strings.ContainsAny(word, "0123456789") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package collectors
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	clients []map[string]string
+}
+
+func (r *recordingObserver) Observe(clients []map[string]string, now time.Time) {
+	r.clients = clients
+}
+
+func (r *recordingObserver) macs() []string {
+	var macs []string
+	for _, client := range r.clients {
+		macs = append(macs, client["MACAddress"])
+	}
+	return macs
+}
+
+func TestRandomizedMerger(t *testing.T) {
+	observer := &recordingObserver{}
+	m := NewRandomizedMerger(0, observer)
+	now := time.Now()
+
+	m.Observe([]map[string]string{
+		{"Name": "Annas-Pixel", "MACAddress": "da:a1:19:00:00:01", "IPAddress": "10.0.0.2"},
+		{"Name": "nas", "MACAddress": "00:11:32:00:00:03"},
+		{"Name": "--", "MACAddress": "DA:A1:19:00:00:09"},
+	}, now)
+	if want := []string{"DA:A1:19:00:00:01", "00:11:32:00:00:03", "DA:A1:19:00:00:09"}; !reflect.DeepEqual(observer.macs(), want) {
+		t.Errorf("got %v, want %v", observer.macs(), want)
+	}
+
+	/* The phone rotated its address and the laptop shows up twice while it roams */
+	clients := []map[string]string{
+		{"Name": "annas-pixel", "MACAddress": "A6:83:E7:00:00:02", "IPAddress": "10.0.0.5"},
+		{"Name": "Laptop", "MACAddress": "AE:00:00:00:00:04"},
+		{"Name": "Laptop", "MACAddress": "AE:00:00:00:00:05"},
+		{"Name": "nas", "MACAddress": "00:11:32:00:00:03"},
+	}
+	m.Observe(clients, now.Add(time.Minute))
+	if want := []string{"DA:A1:19:00:00:01", "AE:00:00:00:00:04", "00:11:32:00:00:03"}; !reflect.DeepEqual(observer.macs(), want) {
+		t.Errorf("got %v, want %v", observer.macs(), want)
+	}
+	if got := observer.clients[0]["IPAddress"]; got != "10.0.0.5" {
+		t.Errorf("got IP address %s for the phone, want the current one", got)
+	}
+	if got := clients[0]["MACAddress"]; got != "A6:83:E7:00:00:02" {
+		t.Errorf("the clients of the collector were changed to %s", got)
+	}
+}
+
+func TestRandomizedMergerGenericNames(t *testing.T) {
+	observer := &recordingObserver{}
+	m := NewRandomizedMerger(0, observer)
+
+	/* Two iPhones with their default name are two devices */
+	m.Observe([]map[string]string{
+		{"Name": "iPhone", "MACAddress": "DA:A1:19:00:00:01"},
+		{"Name": "iPhone", "MACAddress": "DA:A1:19:00:00:02"},
+		{"Name": "Galaxy-S21", "MACAddress": "DA:A1:19:00:00:03"},
+		{"Name": "Galaxy-S21", "MACAddress": "DA:A1:19:00:00:04"},
+		{"Name": " ", "MACAddress": "DA:A1:19:00:00:05"},
+		{"Name": " ", "MACAddress": "DA:A1:19:00:00:06"},
+	}, time.Now())
+	if got := len(observer.clients); got != 6 {
+		t.Errorf("got %d clients, want 6: %v", got, observer.macs())
+	}
+
+	for name, want := range map[string]bool{
+		"iphone":            true,
+		"galaxy-s21-ultra":  true,
+		"pixel 7 pro":       true,
+		"annas-iphone":      false,
+		"iphone-de-maria":   false,
+		"galaxy-of-thieves": false,
+	} {
+		if got := genericName(name); got != want {
+			t.Errorf("%s: got %v for a generic name, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRandomizedMergerForgets(t *testing.T) {
+	observer := &recordingObserver{}
+	m := NewRandomizedMerger(24*time.Hour, observer)
+	now := time.Now()
+
+	m.Observe([]map[string]string{{"Name": "Laptop", "MACAddress": "AE:00:00:00:00:04"}}, now)
+	m.Observe([]map[string]string{{"Name": "Laptop", "MACAddress": "AE:00:00:00:00:05"}}, now.Add(time.Hour))
+	if want := []string{"AE:00:00:00:00:04"}; !reflect.DeepEqual(observer.macs(), want) {
+		t.Errorf("got %v, want %v", observer.macs(), want)
+	}
+
+	/* Gone for longer than a day, the name is up for the next device */
+	m.Observe(nil, now.Add(30*time.Hour))
+	m.Observe([]map[string]string{{"Name": "Laptop", "MACAddress": "AE:00:00:00:00:06"}}, now.Add(31*time.Hour))
+	if want := []string{"AE:00:00:00:00:06"}; !reflect.DeepEqual(observer.macs(), want) {
+		t.Errorf("got %v, want %v", observer.macs(), want)
+	}
+}
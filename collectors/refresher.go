@@ -0,0 +1,38 @@
+package collectors
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Refresher reads the router outside of scrapes, so the JSON API and service discovery
+// have current data when Prometheus does not scrape the metrics often or at all. read
+// only hands what it read to those observers - the metrics, counters and the other
+// observers of the collector follow the scrapes alone.
+type Refresher struct {
+	read   func() error
+	maxAge time.Duration
+
+	mutex sync.Mutex
+	last  time.Time
+}
+
+func NewRefresher(read func() error, maxAge time.Duration) *Refresher {
+	return &Refresher{read: read, maxAge: maxAge}
+}
+
+// Refresh reads the router. Callers that come in while it runs, or within maxAge of the
+// last refresh, do not read the router again.
+func (r *Refresher) Refresh() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.last) < r.maxAge {
+		return
+	}
+	r.last = time.Now()
+	if err := r.read(); err != nil {
+		slog.Warn("failed to refresh the data of the last scrape", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,39 @@
+package collectors
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefresher(t *testing.T) {
+	var mutex sync.Mutex
+	var reads int
+	r := NewRefresher(func() error {
+		mutex.Lock()
+		reads++
+		mutex.Unlock()
+		/* Long enough for the other callers to queue up behind this one */
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Refresh()
+		}()
+	}
+	wg.Wait()
+	if reads != 1 {
+		t.Errorf("read %d times for concurrent refreshes", reads)
+	}
+
+	r.last = time.Now().Add(-2 * time.Hour)
+	r.Refresh()
+	if reads != 2 {
+		t.Errorf("read %d times after the data got old", reads)
+	}
+}
@@ -0,0 +1,147 @@
+package collectors
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DRuggeri/netgear_client"
+	"github.com/DRuggeri/netgear_exporter/soap"
+)
+
+/* The answer of the fake router to a SOAP action: the response fields, or the response code when it is set */
+type fakeAction struct {
+	fields map[string]string
+	code   string
+}
+
+/* A router answering SOAP actions, keyed by service and action as in "DeviceConfig:1#GetQoSEnableStatus", from a table tests can change between scrapes, and GetGenericPortMappingEntry of UPnP IGD from a list of mappings */
+type fakeRouter struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	actions  map[string]fakeAction
+	mappings []soap.PortMapping
+	calls    map[string]int
+}
+
+const (
+	upnpControlPath = "/upnp/control/WANIPConn1"
+	soapURNPrefix   = "urn:NETGEAR-ROUTER:service:"
+)
+
+func newFakeRouter(t *testing.T, actions map[string]fakeAction) *fakeRouter {
+	r := &fakeRouter{actions: actions, calls: make(map[string]int)}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *fakeRouter) set(action string, answer fakeAction) {
+	r.mutex.Lock()
+	r.actions[action] = answer
+	r.mutex.Unlock()
+}
+
+func (r *fakeRouter) setMappings(mappings []soap.PortMapping) {
+	r.mutex.Lock()
+	r.mappings = mappings
+	r.mutex.Unlock()
+}
+
+func (r *fakeRouter) called(action string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.calls[action]
+}
+
+func (r *fakeRouter) soapClient(t *testing.T) *soap.Client {
+	client, err := soap.NewClient(r.URL, false, "admin", "secret", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func (r *fakeRouter) netgearClient(t *testing.T) *netgear_client.NetgearClient {
+	client, err := netgear_client.NewNetgearClient(r.URL, false, "admin", "secret", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func (r *fakeRouter) upnpClient() *soap.UPnPClient {
+	return soap.NewUPnPClient(r.URL+upnpControlPath, 2)
+}
+
+func (r *fakeRouter) serve(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	action := strings.Trim(req.Header.Get("SOAPAction"), `"`)
+	if req.URL.Path == upnpControlPath {
+		r.calls[action[strings.Index(action, "#")+1:]]++
+		r.serveUPnP(w, req)
+		return
+	}
+
+	/* A wrong service is as unsupported as a wrong action */
+	action = strings.TrimPrefix(action, soapURNPrefix)
+	r.calls[action]++
+	answer, found := r.actions[action]
+	switch {
+	case action == "DeviceConfig:1#SOAPLogin":
+		answer = fakeAction{}
+	case !found:
+		answer = fakeAction{code: "501"}
+	}
+	code := answer.code
+	if code == "" {
+		code = "000"
+	}
+
+	var fields strings.Builder
+	for name, value := range answer.fields {
+		fmt.Fprintf(&fields, "<New%s>%s</New%s>\n", name, html.EscapeString(value), name)
+	}
+	name := action[strings.Index(action, "#")+1:]
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<soap-env:Envelope xmlns:soap-env="http://schemas.xmlsoap.org/soap/envelope/">
+<soap-env:Body>
+<m:%sResponse xmlns:m="urn:NETGEAR-ROUTER:service:Test:1">
+%s</m:%sResponse>
+<ResponseCode>%s</ResponseCode>
+</soap-env:Body>
+</soap-env:Envelope>`, name, fields.String(), name, code)
+}
+
+func (r *fakeRouter) serveUPnP(w http.ResponseWriter, req *http.Request) {
+	data, _ := io.ReadAll(req.Body)
+	_, rest, _ := strings.Cut(string(data), "<NewPortMappingIndex>")
+	value, _, _ := strings.Cut(rest, "<")
+	index, _ := strconv.Atoi(value)
+
+	if index >= len(r.mappings) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>
+<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>713</errorCode></UPnPError></detail>
+</s:Fault></s:Body></s:Envelope>`)
+		return
+	}
+
+	m := r.mappings[index]
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetGenericPortMappingEntryResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewExternalPort>%s</NewExternalPort><NewProtocol>%s</NewProtocol><NewInternalPort>%s</NewInternalPort>
+<NewInternalClient>%s</NewInternalClient><NewEnabled>%t</NewEnabled><NewPortMappingDescription>%s</NewPortMappingDescription>
+</u:GetGenericPortMappingEntryResponse></s:Body></s:Envelope>`, m.ExternalPort, m.Protocol, m.InternalPort, m.InternalClient, m.Enabled, html.EscapeString(m.Description))
+}
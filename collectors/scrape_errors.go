@@ -0,0 +1,22 @@
+package collectors
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newRequestID returns a random ID for one scrape of the router. The errors of the scrape
+// are logged with it as request_id.
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// countScrapeError counts a failed scrape with the request ID as exemplar, so an
+// OpenMetrics scrape of the error counter points at the log lines of the latest failure
+func countScrapeError(counter prometheus.Counter, requestID string) {
+	counter.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"request_id": requestID})
+}
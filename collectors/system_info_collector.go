@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/DRuggeri/netgear_client"
@@ -15,6 +16,8 @@ type SystemInfo struct {
 	namespace string
 	client    *netgear_client.NetgearClient
 	metrics   map[string]prometheus.Gauge
+	observers []StatsObserver
+	mutex     sync.Mutex
 
 	scrapesTotalMetric              prometheus.Counter
 	scrapeErrorsTotalMetric         prometheus.Counter
@@ -31,7 +34,15 @@ var SystemInfoFields = [...]string{
 	"AvailableFlash",
 }
 
-func NewSystemInfoCollector(namespace string, client *netgear_client.NetgearClient) *SystemInfo {
+// StatsObserver is handed the parsed stats of the SystemInfo or Traffic collector on every
+// successful scrape, keyed by the names the router uses
+type StatsObserver interface {
+	ObserveStats(stats map[string]float64, now time.Time)
+}
+
+// NewSystemInfoCollector creates the collector. The parsed stats are handed to the
+// observers on every successful scrape.
+func NewSystemInfoCollector(namespace string, client *netgear_client.NetgearClient, observers ...StatsObserver) *SystemInfo {
 	metrics := make(map[string]prometheus.Gauge)
 	for _, name := range SystemInfoFields {
 		metrics[name] = prometheus.NewGauge(
@@ -93,6 +104,7 @@ func NewSystemInfoCollector(namespace string, client *netgear_client.NetgearClie
 		namespace: namespace,
 		client:    client,
 		metrics:   metrics,
+		observers: observers,
 
 		scrapesTotalMetric:              scrapesTotalMetric,
 		scrapeErrorsTotalMetric:         scrapeErrorsTotalMetric,
@@ -103,15 +115,22 @@ func NewSystemInfoCollector(namespace string, client *netgear_client.NetgearClie
 }
 
 func (c *SystemInfo) Collect(ch chan<- prometheus.Metric) {
+	/* Concurrent scrapes would interleave their values */
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	var begun = time.Now()
+	requestID := newRequestID()
 
 	errorMetric := float64(0)
 	stats, err := c.client.GetSystemInfo()
 	if err != nil {
-		slog.Error("error while collecting system info: %v", slog.String("error", err.Error()))
+		slog.Error("error while collecting system info", slog.String("request_id", requestID), slog.String("error", err.Error()))
 		errorMetric = float64(1)
-		c.scrapeErrorsTotalMetric.Inc()
+		countScrapeError(c.scrapeErrorsTotalMetric, requestID)
 	} else {
+		values := make(map[string]float64)
+
 		/* Loop through the names we expect */
 		for _, name := range SystemInfoFields {
 			/* Check first that we got what we expect */
@@ -119,12 +138,17 @@ func (c *SystemInfo) Collect(ch chan<- prometheus.Metric) {
 				var metric float64
 				metric, _ = strconv.ParseFloat(val, 64)
 
+				values[name] = metric
 				c.metrics[name].Set(metric)
 				c.metrics[name].Collect(ch)
 			} else {
 				slog.Warn(fmt.Sprintf("system info stat named '%s' missing from results!", name))
 			}
 		}
+
+		for _, observer := range c.observers {
+			observer.ObserveStats(values, begun)
+		}
 	}
 
 	c.scrapeErrorsTotalMetric.Collect(ch)
@@ -142,6 +166,27 @@ func (c *SystemInfo) Collect(ch chan<- prometheus.Metric) {
 	c.lastScrapeDurationSecondsMetric.Collect(ch)
 }
 
+// Read reads the system info and hands it to observers only, without touching the
+// metrics or the observers of the scrapes
+func (c *SystemInfo) Read(observers ...StatsObserver) error {
+	begun := time.Now()
+	stats, err := c.client.GetSystemInfo()
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]float64)
+	for _, name := range SystemInfoFields {
+		if val, ok := stats[name]; ok {
+			values[name], _ = strconv.ParseFloat(val, 64)
+		}
+	}
+	for _, observer := range observers {
+		observer.ObserveStats(values, begun)
+	}
+	return nil
+}
+
 func (c *SystemInfo) Describe(ch chan<- *prometheus.Desc) {
 	for _, name := range SystemInfoFields {
 		c.metrics[name].Describe(ch)
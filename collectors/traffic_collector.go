@@ -1,21 +1,62 @@
 package collectors
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/DRuggeri/netgear_client"
+	"github.com/DRuggeri/netgear_exporter/soap"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+/* How long an action the router answered as unsupported is skipped */
+const unsupportedRetry = time.Hour
+
+/* Netgear reports traffic in MB on every firmware seen so far */
+const DefaultTrafficUnitBytes = float64(1000000)
+
 type TrafficCollector struct {
-	namespace string
-	client    *netgear_client.NetgearClient
-	metrics   map[string]prometheus.Gauge
+	namespace   string
+	client      *netgear_client.NetgearClient
+	unitBytes   float64
+	flatMetrics bool
+	quota       *Quota
+	meterClient *soap.Client
+	observers   []StatsObserver
+	metrics     map[string]prometheus.Gauge
+	/* Scrapes rebuild the series and move the throughput baseline one at a time */
+	mutex sync.Mutex
+	/* Whether the last scrape found the traffic meter disabled, so reads outside of scrapes skip the statistics as well */
+	meterDisabled atomic.Bool
+
+	/* Actions the router does not support are asked again after unsupportedRetry and only logged the first time */
+	unsupportedMutex sync.Mutex
+	unsupportedUntil map[string]time.Time
+	logged           map[string]bool
+
+	meterEnabledMetric      prometheus.Gauge
+	meterInfoMetric         *prometheus.GaugeVec
+	meterLimitBytesMetric   prometheus.Gauge
+	meterLimitSecondsMetric prometheus.Gauge
+	meterRestartDayMetric   prometheus.Gauge
+
+	bytesMetric             *prometheus.GaugeVec
+	averageBytesMetric      *prometheus.GaugeVec
+	connectionSecondsMetric *prometheus.GaugeVec
 
+	downloadThroughputMetric prometheus.Gauge
+	uploadThroughputMetric   prometheus.Gauge
+	throughputMutex          sync.Mutex
+	todayDownload            resettingCounter
+	todayUpload              resettingCounter
+
+	trafficParseErrorsTotalMetric          prometheus.Counter
 	trafficScrapesTotalMetric              prometheus.Counter
 	trafficScrapeErrorsTotalMetric         prometheus.Counter
 	lastTrafficScrapeErrorMetric           prometheus.Gauge
@@ -47,7 +88,27 @@ var TrafficCollectorFields = [...]string{
 	"LastMonthUploadAverage",
 }
 
-func NewTrafficCollector(namespace string, client *netgear_client.NetgearClient) *TrafficCollector {
+/* The periods the router keeps statistics for, in the order their names must be matched */
+var trafficPeriods = [...]struct {
+	prefix string
+	label  string
+}{
+	{"Today", "today"},
+	{"Yesterday", "yesterday"},
+	{"Week", "week"},
+	{"LastMonth", "last_month"},
+	{"Month", "month"},
+}
+
+// NewTrafficCollector creates the collector. unitBytes is the number of bytes in the
+// unit the router reports traffic in, see DefaultTrafficUnitBytes. flatMetrics also
+// exports one metric per router stat under the names and in the units the exporter used
+// to. quota is fed with today's traffic on every scrape unless it is nil. meterClient is
+// used to read the traffic meter settings; without it the meter is assumed to be enabled.
+// The parsed stats, in bytes and seconds, are handed to the observers on every successful
+// scrape.
+func NewTrafficCollector(namespace string, client *netgear_client.NetgearClient, unitBytes float64, flatMetrics bool, quota *Quota, meterClient *soap.Client, observers ...StatsObserver) *TrafficCollector {
+	/* The flat metrics keep the names and units of the exporter before the labeled series */
 	metrics := make(map[string]prometheus.Gauge)
 	for _, name := range TrafficCollectorFields {
 		metrics[name] = prometheus.NewGauge(
@@ -60,6 +121,109 @@ func NewTrafficCollector(namespace string, client *netgear_client.NetgearClient)
 		)
 	}
 
+	meterEnabledMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic_meter",
+			Name:      "enabled",
+			Help:      "Whether the traffic meter of the router is enabled (1 for enabled, 0 for disabled).",
+		},
+	)
+
+	meterInfoMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic_meter",
+			Name:      "info",
+			Help:      "Traffic meter settings with a control_option label telling what the monthly limit applies to.",
+		},
+		[]string{"control_option"},
+	)
+
+	meterLimitBytesMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic_meter",
+			Name:      "limit_bytes",
+			Help:      "Monthly traffic volume limit of the traffic meter.",
+		},
+	)
+
+	meterLimitSecondsMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic_meter",
+			Name:      "limit_seconds",
+			Help:      "Monthly connection time limit of the traffic meter.",
+		},
+	)
+
+	meterRestartDayMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic_meter",
+			Name:      "restart_day",
+			Help:      "Day of the month the traffic meter starts counting over.",
+		},
+	)
+
+	bytesMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic",
+			Name:      "bytes",
+			Help:      "Traffic counted by the router in the period with period and direction labels.",
+		},
+		[]string{"period", "direction"},
+	)
+
+	averageBytesMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic",
+			Name:      "average_bytes",
+			Help:      "Average daily traffic counted by the router in the period with period and direction labels.",
+		},
+		[]string{"period", "direction"},
+	)
+
+	connectionSecondsMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic",
+			Name:      "connection_seconds",
+			Help:      "Time the router was connected to the internet in the period with a period label.",
+		},
+		[]string{"period"},
+	)
+
+	downloadThroughputMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic",
+			Name:      "download_bytes_per_second",
+			Help:      "Average download throughput since the previous scrape, derived from the 'TodayDownload' traffic metric.",
+		},
+	)
+
+	uploadThroughputMetric := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "traffic",
+			Name:      "upload_bytes_per_second",
+			Help:      "Average upload throughput since the previous scrape, derived from the 'TodayUpload' traffic metric.",
+		},
+	)
+
+	trafficParseErrorsTotalMetric := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "traffic_parse_errors",
+			Name:      "total",
+			Help:      "Total number of traffic stats from the router that could not be parsed.",
+		},
+	)
+
 	trafficScrapesTotalMetric := prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Namespace: namespace,
@@ -106,10 +270,32 @@ func NewTrafficCollector(namespace string, client *netgear_client.NetgearClient)
 	)
 
 	return &TrafficCollector{
-		namespace: namespace,
-		client:    client,
-		metrics:   metrics,
+		namespace:   namespace,
+		client:      client,
+		unitBytes:   unitBytes,
+		flatMetrics: flatMetrics,
+		quota:       quota,
+		meterClient: meterClient,
+		observers:   observers,
+		metrics:     metrics,
+
+		unsupportedUntil: make(map[string]time.Time),
+		logged:           make(map[string]bool),
 
+		meterEnabledMetric:      meterEnabledMetric,
+		meterInfoMetric:         meterInfoMetric,
+		meterLimitBytesMetric:   meterLimitBytesMetric,
+		meterLimitSecondsMetric: meterLimitSecondsMetric,
+		meterRestartDayMetric:   meterRestartDayMetric,
+
+		bytesMetric:             bytesMetric,
+		averageBytesMetric:      averageBytesMetric,
+		connectionSecondsMetric: connectionSecondsMetric,
+
+		downloadThroughputMetric: downloadThroughputMetric,
+		uploadThroughputMetric:   uploadThroughputMetric,
+
+		trafficParseErrorsTotalMetric:          trafficParseErrorsTotalMetric,
 		trafficScrapesTotalMetric:              trafficScrapesTotalMetric,
 		trafficScrapeErrorsTotalMetric:         trafficScrapeErrorsTotalMetric,
 		lastTrafficScrapeErrorMetric:           lastTrafficScrapeErrorMetric,
@@ -119,39 +305,75 @@ func NewTrafficCollector(namespace string, client *netgear_client.NetgearClient)
 }
 
 func (c *TrafficCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	var begun = time.Now()
+	requestID := newRequestID()
 
 	errorMetric := float64(0)
-	stats, err := c.client.GetTrafficMeterStatistics()
+	meterEnabled, err := c.collectMeterSettings(ch)
 	if err != nil {
-		slog.Error("error while collecting traffic statistics", slog.String("error", err.Error()))
+		slog.Error("error while collecting traffic meter settings", slog.String("request_id", requestID), slog.String("error", err.Error()))
+		errorMetric = float64(1)
+	}
+	c.meterDisabled.Store(!meterEnabled)
+
+	if !meterEnabled {
+		slog.Debug("traffic meter is disabled on the router, skipping traffic statistics")
+	} else if stats, err := c.client.GetTrafficMeterStatistics(); err != nil {
+		slog.Error("error while collecting traffic statistics", slog.String("request_id", requestID), slog.String("error", err.Error()))
 		errorMetric = float64(1)
-		c.trafficScrapeErrorsTotalMetric.Inc()
 	} else {
+		values := make(map[string]float64)
+
+		/* Start over so a stat that failed to parse does not keep its previous value */
+		c.bytesMetric.Reset()
+		c.averageBytesMetric.Reset()
+		c.connectionSecondsMetric.Reset()
+
 		/* Loop through the names we expect */
 		for _, name := range TrafficCollectorFields {
 			/* Check first that we got what we expect */
 			if val, ok := stats[name]; ok {
-				var metric float64
-				/* Convert time entries in H:M format to seconds */
-				if strings.HasSuffix(name, "Time") {
-					times := strings.Split(val, ":")
-					tmp, _ := strconv.ParseFloat(times[0], 64)
-					metric = tmp * float64(3600)
-					tmp, _ = strconv.ParseFloat(times[1], 64)
-					metric += tmp * float64(60)
-				} else {
-					metric, _ = strconv.ParseFloat(val, 64)
+				metric, err := c.parseStat(name, val)
+				if err != nil {
+					slog.Warn("failed to parse traffic stat", slog.String("error", err.Error()))
+					c.trafficParseErrorsTotalMetric.Inc()
+					continue
 				}
 
-				c.metrics[name].Set(metric)
-				c.metrics[name].Collect(ch)
+				values[name] = metric
+				c.setSeries(name, metric)
+
+				if c.flatMetrics {
+					c.metrics[name].Set(flatValue(name, val, metric))
+					c.metrics[name].Collect(ch)
+				}
 			} else {
-				slog.Warn(fmt.Sprintf("traffic stat named '%s' missing from results!", name))
+				slog.Warn("traffic stat missing from results", slog.String("name", name))
 			}
 		}
+
+		c.bytesMetric.Collect(ch)
+		c.averageBytesMetric.Collect(ch)
+		c.connectionSecondsMetric.Collect(ch)
+
+		c.collectThroughput(ch, values)
+
+		if c.quota != nil {
+			c.quota.Observe(values, time.Now())
+		}
+
+		for _, observer := range c.observers {
+			observer.ObserveStats(values, begun)
+		}
 	}
 
+	if errorMetric != 0 {
+		countScrapeError(c.trafficScrapeErrorsTotalMetric, requestID)
+	}
+	c.trafficParseErrorsTotalMetric.Collect(ch)
 	c.trafficScrapeErrorsTotalMetric.Collect(ch)
 
 	c.trafficScrapesTotalMetric.Inc()
@@ -167,11 +389,233 @@ func (c *TrafficCollector) Collect(ch chan<- prometheus.Metric) {
 	c.lastTrafficScrapeDurationSecondsMetric.Collect(ch)
 }
 
-func (c *TrafficCollector) Describe(ch chan<- *prometheus.Desc) {
+// Read reads the traffic statistics and hands them to observers only, without touching
+// the metrics, the quota, the throughput or the observers of the scrapes
+func (c *TrafficCollector) Read(observers ...StatsObserver) error {
+	if c.meterDisabled.Load() {
+		return nil
+	}
+
+	begun := time.Now()
+	stats, err := c.client.GetTrafficMeterStatistics()
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]float64)
 	for _, name := range TrafficCollectorFields {
-		c.metrics[name].Describe(ch)
+		/* What is missing or fails to parse is logged and counted by the scrapes */
+		if val, ok := stats[name]; ok {
+			if metric, err := c.parseStat(name, val); err == nil {
+				values[name] = metric
+			}
+		}
+	}
+	for _, observer := range observers {
+		observer.ObserveStats(values, begun)
+	}
+	return nil
+}
+
+// Read whether the traffic meter is enabled and how it is configured. The statistics
+// of a disabled meter are meaningless, so they are only collected when it is enabled.
+func (c *TrafficCollector) collectMeterSettings(ch chan<- prometheus.Metric) (bool, error) {
+	if c.meterClient == nil || c.unsupported("GetTrafficMeterEnabled") {
+		return true, nil
+	}
+
+	status, err := c.meterClient.GetTrafficMeterEnabled()
+	var respErr *soap.ResponseError
+	if errors.As(err, &respErr) && respErr.Unsupported() {
+		c.markUnsupported("GetTrafficMeterEnabled", "router does not report whether the traffic meter is enabled", err)
+		return true, nil
+	} else if err != nil {
+		return true, err
 	}
 
+	enabled := strings.TrimSpace(status["TrafficMeterEnable"]) == "1"
+	if enabled {
+		c.meterEnabledMetric.Set(float64(1))
+	} else {
+		c.meterEnabledMetric.Set(float64(0))
+	}
+	c.meterEnabledMetric.Collect(ch)
+
+	if !enabled || c.unsupported("GetTrafficMeterOptions") {
+		return enabled, nil
+	}
+
+	options, err := c.meterClient.GetTrafficMeterOptions()
+	if errors.As(err, &respErr) && respErr.Unsupported() {
+		c.markUnsupported("GetTrafficMeterOptions", "router does not report the traffic meter options", err)
+		return true, nil
+	} else if err != nil {
+		return true, err
+	}
+
+	controlOption := options["ControlOption"]
+	c.meterInfoMetric.Reset()
+	c.meterInfoMetric.WithLabelValues(controlOption).Set(float64(1))
+	c.meterInfoMetric.Collect(ch)
+
+	/* The monthly limit is a volume in the traffic unit or a number of hours for connection time control */
+	if limit, err := strconv.ParseFloat(options["MonthlyLimit"], 64); err != nil {
+		slog.Warn("traffic meter monthly limit is not a number", slog.String("value", options["MonthlyLimit"]))
+	} else if strings.Contains(strings.ToLower(controlOption), "time") {
+		c.meterLimitSecondsMetric.Set(limit * 3600)
+		c.meterLimitSecondsMetric.Collect(ch)
+	} else if !strings.EqualFold(controlOption, "No limit") {
+		c.meterLimitBytesMetric.Set(limit * c.unitBytes)
+		c.meterLimitBytesMetric.Collect(ch)
+	}
+
+	if day, err := strconv.ParseFloat(options["RestartDay"], 64); err == nil {
+		c.meterRestartDayMetric.Set(day)
+		c.meterRestartDayMetric.Collect(ch)
+	}
+
+	return true, nil
+}
+
+/* Whether the router answered the action as unsupported within unsupportedRetry - it will not support it on the next scrape either */
+func (c *TrafficCollector) unsupported(action string) bool {
+	c.unsupportedMutex.Lock()
+	defer c.unsupportedMutex.Unlock()
+	return time.Now().Before(c.unsupportedUntil[action])
+}
+
+/* Skip the action until unsupportedRetry has passed, a firmware update may add it. Logged at debug level the first time only */
+func (c *TrafficCollector) markUnsupported(action string, msg string, err error) {
+	c.unsupportedMutex.Lock()
+	defer c.unsupportedMutex.Unlock()
+	c.unsupportedUntil[action] = time.Now().Add(unsupportedRetry)
+	if !c.logged[action] {
+		c.logged[action] = true
+		slog.Debug(msg, slog.String("error", err.Error()))
+	}
+}
+
+/* Today's counters only ever grow until midnight - their growth between two scrapes is the throughput */
+func (c *TrafficCollector) collectThroughput(ch chan<- prometheus.Metric, values map[string]float64) {
+	c.throughputMutex.Lock()
+	defer c.throughputMutex.Unlock()
+
+	now := time.Now()
+	for _, t := range []struct {
+		name    string
+		counter *resettingCounter
+		metric  prometheus.Gauge
+	}{
+		{"TodayDownload", &c.todayDownload, c.downloadThroughputMetric},
+		{"TodayUpload", &c.todayUpload, c.uploadThroughputMetric},
+	} {
+		bytes, found := values[t.name]
+		if !found {
+			continue
+		}
+
+		delta, elapsed, ok := t.counter.observe(bytes, now)
+		if !ok {
+			continue
+		}
+		t.metric.Set(delta / elapsed.Seconds())
+		t.metric.Collect(ch)
+	}
+}
+
+/* Split a stat name like LastMonthDownloadAverage into its period and what it measures */
+func (c *TrafficCollector) setSeries(name string, value float64) {
+	for _, period := range trafficPeriods {
+		if !strings.HasPrefix(name, period.prefix) {
+			continue
+		}
+
+		switch strings.TrimPrefix(name, period.prefix) {
+		case "ConnectionTime":
+			c.connectionSecondsMetric.WithLabelValues(period.label).Set(value)
+		case "Download":
+			c.bytesMetric.WithLabelValues(period.label, "download").Set(value)
+		case "Upload":
+			c.bytesMetric.WithLabelValues(period.label, "upload").Set(value)
+		case "DownloadAverage":
+			c.averageBytesMetric.WithLabelValues(period.label, "download").Set(value)
+		case "UploadAverage":
+			c.averageBytesMetric.WithLabelValues(period.label, "upload").Set(value)
+		default:
+			continue
+		}
+		return
+	}
+}
+
+func isConnectionTime(name string) bool {
+	return strings.HasSuffix(name, "Time")
+}
+
+/* Convert a traffic stat to base units: connection times in hh:mm format to seconds, traffic volumes to bytes */
+func (c *TrafficCollector) parseStat(name string, val string) (float64, error) {
+	if isConnectionTime(name) {
+		seconds, err := parseConnectionTime(val)
+		if err != nil {
+			return 0, fmt.Errorf("traffic stat named '%s': %v", name, err)
+		}
+		return seconds, nil
+	}
+
+	metric, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	if err != nil {
+		return 0, fmt.Errorf("traffic stat named '%s' has invalid value '%s'", name, val)
+	}
+	return metric * c.unitBytes, nil
+}
+
+/* The flat metrics report traffic as the router does, connection times were always in seconds */
+func flatValue(name string, val string, metric float64) float64 {
+	if isConnectionTime(name) {
+		return metric
+	}
+	raw, _ := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	return raw
+}
+
+func parseConnectionTime(val string) (float64, error) {
+	times := strings.Split(strings.TrimSpace(val), ":")
+	if len(times) != 2 {
+		return 0, fmt.Errorf("connection time '%s' is not in hh:mm format", val)
+	}
+
+	hours, err := strconv.ParseUint(times[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("connection time '%s' has invalid hours", val)
+	}
+	minutes, err := strconv.ParseUint(times[1], 10, 64)
+	if err != nil || minutes > 59 {
+		return 0, fmt.Errorf("connection time '%s' has invalid minutes", val)
+	}
+	return float64(hours*3600 + minutes*60), nil
+}
+
+func (c *TrafficCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.meterEnabledMetric.Describe(ch)
+	c.meterInfoMetric.Describe(ch)
+	c.meterLimitBytesMetric.Describe(ch)
+	c.meterLimitSecondsMetric.Describe(ch)
+	c.meterRestartDayMetric.Describe(ch)
+
+	c.bytesMetric.Describe(ch)
+	c.averageBytesMetric.Describe(ch)
+	c.connectionSecondsMetric.Describe(ch)
+
+	if c.flatMetrics {
+		for _, name := range TrafficCollectorFields {
+			c.metrics[name].Describe(ch)
+		}
+	}
+
+	c.downloadThroughputMetric.Describe(ch)
+	c.uploadThroughputMetric.Describe(ch)
+
+	c.trafficParseErrorsTotalMetric.Describe(ch)
 	c.trafficScrapesTotalMetric.Describe(ch)
 	c.trafficScrapeErrorsTotalMetric.Describe(ch)
 	c.lastTrafficScrapeErrorMetric.Describe(ch)
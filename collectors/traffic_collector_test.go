@@ -0,0 +1,301 @@
+package collectors
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseStat(t *testing.T) {
+	c := NewTrafficCollector("netgear", nil, DefaultTrafficUnitBytes, false, nil, nil)
+
+	for _, test := range []struct {
+		name  string
+		value string
+		want  float64
+	}{
+		{"TodayConnectionTime", "05:30", 19800},
+		{"MonthConnectionTime", "123:07", 443220},
+		{"TodayDownload", "1234.56", 1234560000},
+		{"WeekUploadAverage", "0", 0},
+	} {
+		have, err := c.parseStat(test.name, test.value)
+		if err != nil {
+			t.Errorf("%s=%s: %v", test.name, test.value, err)
+		} else if have != test.want {
+			t.Errorf("%s=%s: want %v, have %v", test.name, test.value, test.want, have)
+		}
+	}
+
+	for _, test := range []struct {
+		name  string
+		value string
+	}{
+		{"TodayConnectionTime", "--"},
+		{"TodayConnectionTime", "5"},
+		{"TodayConnectionTime", "05:75"},
+		{"TodayDownload", "N/A"},
+		{"TodayDownload", ""},
+	} {
+		if have, err := c.parseStat(test.name, test.value); err == nil {
+			t.Errorf("%s=%s: want an error, have %v", test.name, test.value, have)
+		}
+	}
+}
+
+func TestSetSeries(t *testing.T) {
+	c := NewTrafficCollector("netgear", nil, DefaultTrafficUnitBytes, false, nil, nil)
+	for i, name := range TrafficCollectorFields {
+		c.setSeries(name, float64(i))
+	}
+
+	if have := testutil.CollectAndCount(c.bytesMetric); have != 10 {
+		t.Errorf("want 10 traffic series, have %d", have)
+	}
+	if have := testutil.CollectAndCount(c.averageBytesMetric); have != 6 {
+		t.Errorf("want 6 average traffic series, have %d", have)
+	}
+	if have := testutil.CollectAndCount(c.connectionSecondsMetric); have != 5 {
+		t.Errorf("want 5 connection time series, have %d", have)
+	}
+
+	/* LastMonth must not be mistaken for Month */
+	for _, test := range []struct {
+		metric float64
+		want   float64
+	}{
+		{testutil.ToFloat64(c.bytesMetric.WithLabelValues("month", "download")), 12},
+		{testutil.ToFloat64(c.bytesMetric.WithLabelValues("last_month", "download")), 17},
+		{testutil.ToFloat64(c.averageBytesMetric.WithLabelValues("last_month", "upload")), 20},
+		{testutil.ToFloat64(c.connectionSecondsMetric.WithLabelValues("today")), 0},
+	} {
+		if test.metric != test.want {
+			t.Errorf("want %v, have %v", test.want, test.metric)
+		}
+	}
+}
+
+func TestFlatMetrics(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{
+		"DeviceConfig:1#GetTrafficMeterStatistics": {fields: map[string]string{
+			"TodayConnectionTime": "05:30",
+			"TodayDownload":       "1,234.56",
+			"WeekDownload":        "8000/1142.86",
+		}},
+	})
+	c := NewTrafficCollector("netgear", router.netgearClient(t), DefaultTrafficUnitBytes, true, nil, nil)
+
+	/* The names and units of the exporter before the labeled series */
+	want := `
+# HELP netgear_traffic_todayconnectiontime Value of the 'TodayConnectionTime' traffic metric from the router
+# TYPE netgear_traffic_todayconnectiontime gauge
+netgear_traffic_todayconnectiontime 19800
+# HELP netgear_traffic_todaydownload Value of the 'TodayDownload' traffic metric from the router
+# TYPE netgear_traffic_todaydownload gauge
+netgear_traffic_todaydownload 1234.56
+# HELP netgear_traffic_weekdownload Value of the 'WeekDownload' traffic metric from the router
+# TYPE netgear_traffic_weekdownload gauge
+netgear_traffic_weekdownload 8000
+# HELP netgear_traffic_weekdownloadaverage Value of the 'WeekDownloadAverage' traffic metric from the router
+# TYPE netgear_traffic_weekdownloadaverage gauge
+netgear_traffic_weekdownloadaverage 1142.86
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "netgear_traffic_todayconnectiontime", "netgear_traffic_todaydownload", "netgear_traffic_weekdownload", "netgear_traffic_weekdownloadaverage"); err != nil {
+		t.Error(err)
+	}
+	if have := testutil.ToFloat64(c.bytesMetric.WithLabelValues("today", "download")); have != 1234560000 {
+		t.Errorf("want the labeled series in bytes, have %v", have)
+	}
+}
+
+func TestTrafficMeterSettings(t *testing.T) {
+	stats := fakeAction{fields: map[string]string{"TodayDownload": "1.5", "TodayUpload": "0.5"}}
+
+	for _, test := range []struct {
+		name    string
+		enabled string
+		options map[string]string
+		want    string
+	}{
+		{
+			name:    "volume limit",
+			enabled: "1",
+			options: map[string]string{"ControlOption": "Download only", "MonthlyLimit": "500", "RestartDay": "13"},
+			want: `
+# HELP netgear_traffic_meter_enabled Whether the traffic meter of the router is enabled (1 for enabled, 0 for disabled).
+# TYPE netgear_traffic_meter_enabled gauge
+netgear_traffic_meter_enabled 1
+# HELP netgear_traffic_meter_info Traffic meter settings with a control_option label telling what the monthly limit applies to.
+# TYPE netgear_traffic_meter_info gauge
+netgear_traffic_meter_info{control_option="Download only"} 1
+# HELP netgear_traffic_meter_limit_bytes Monthly traffic volume limit of the traffic meter.
+# TYPE netgear_traffic_meter_limit_bytes gauge
+netgear_traffic_meter_limit_bytes 5e+08
+# HELP netgear_traffic_meter_restart_day Day of the month the traffic meter starts counting over.
+# TYPE netgear_traffic_meter_restart_day gauge
+netgear_traffic_meter_restart_day 13
+`,
+		},
+		{
+			name:    "connection time limit",
+			enabled: "1",
+			options: map[string]string{"ControlOption": "Connection time", "MonthlyLimit": "100", "RestartDay": "1"},
+			want: `
+# HELP netgear_traffic_meter_enabled Whether the traffic meter of the router is enabled (1 for enabled, 0 for disabled).
+# TYPE netgear_traffic_meter_enabled gauge
+netgear_traffic_meter_enabled 1
+# HELP netgear_traffic_meter_info Traffic meter settings with a control_option label telling what the monthly limit applies to.
+# TYPE netgear_traffic_meter_info gauge
+netgear_traffic_meter_info{control_option="Connection time"} 1
+# HELP netgear_traffic_meter_limit_seconds Monthly connection time limit of the traffic meter.
+# TYPE netgear_traffic_meter_limit_seconds gauge
+netgear_traffic_meter_limit_seconds 360000
+# HELP netgear_traffic_meter_restart_day Day of the month the traffic meter starts counting over.
+# TYPE netgear_traffic_meter_restart_day gauge
+netgear_traffic_meter_restart_day 1
+`,
+		},
+		{
+			name:    "disabled",
+			enabled: "0",
+			want: `
+# HELP netgear_traffic_meter_enabled Whether the traffic meter of the router is enabled (1 for enabled, 0 for disabled).
+# TYPE netgear_traffic_meter_enabled gauge
+netgear_traffic_meter_enabled 0
+`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			router := newFakeRouter(t, map[string]fakeAction{
+				"DeviceConfig:1#GetTrafficMeterEnabled":    {fields: map[string]string{"TrafficMeterEnable": test.enabled}},
+				"DeviceConfig:1#GetTrafficMeterOptions":    {fields: test.options},
+				"DeviceConfig:1#GetTrafficMeterStatistics": stats,
+			})
+			c := NewTrafficCollector("netgear", router.netgearClient(t), DefaultTrafficUnitBytes, false, nil, router.soapClient(t))
+
+			meterMetrics := []string{"netgear_traffic_meter_enabled", "netgear_traffic_meter_info", "netgear_traffic_meter_limit_bytes", "netgear_traffic_meter_limit_seconds", "netgear_traffic_meter_restart_day"}
+			if err := testutil.CollectAndCompare(c, strings.NewReader(test.want), meterMetrics...); err != nil {
+				t.Error(err)
+			}
+
+			/* The statistics of a disabled meter are not read at all */
+			if got, want := router.called("DeviceConfig:1#GetTrafficMeterStatistics") > 0, test.enabled == "1"; got != want {
+				t.Errorf("got %v for reading the statistics, want %v", got, want)
+			}
+			if got := testutil.ToFloat64(c.lastTrafficScrapeErrorMetric); got != 0 {
+				t.Errorf("got %v for the last scrape error, want 0", got)
+			}
+		})
+	}
+}
+
+func TestTrafficMeterUnsupported(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{
+		"DeviceConfig:1#GetTrafficMeterEnabled":    {code: "501"},
+		"DeviceConfig:1#GetTrafficMeterStatistics": {fields: map[string]string{"TodayDownload": "1.5", "TodayUpload": "0.5"}},
+	})
+	c := NewTrafficCollector("netgear", router.netgearClient(t), DefaultTrafficUnitBytes, false, nil, router.soapClient(t))
+
+	/* Without the settings the meter is assumed to be enabled, and the router is not asked again */
+	testutil.CollectAndCount(c)
+	testutil.CollectAndCount(c)
+	if got := router.called("DeviceConfig:1#GetTrafficMeterEnabled"); got != 1 {
+		t.Errorf("got %d calls of GetTrafficMeterEnabled, want 1", got)
+	}
+	if got := testutil.CollectAndCount(c, "netgear_traffic_meter_enabled"); got != 0 {
+		t.Errorf("got %d meter enabled series, want 0", got)
+	}
+	if got := testutil.ToFloat64(c.bytesMetric.WithLabelValues("today", "download")); got != 1500000 {
+		t.Errorf("got %v bytes downloaded today, want 1500000", got)
+	}
+	if got := testutil.ToFloat64(c.lastTrafficScrapeErrorMetric); got != 0 {
+		t.Errorf("got %v for the last scrape error, want 0", got)
+	}
+
+	/* It is asked again after a while, in case a firmware update added it */
+	c.unsupportedUntil["GetTrafficMeterEnabled"] = time.Now().Add(-time.Second)
+	testutil.CollectAndCount(c)
+	if got := router.called("DeviceConfig:1#GetTrafficMeterEnabled"); got != 2 {
+		t.Errorf("got %d calls of GetTrafficMeterEnabled after the retry time, want 2", got)
+	}
+
+	/* Options that are unsupported while the enabled state is reported */
+	router.set("DeviceConfig:1#GetTrafficMeterEnabled", fakeAction{fields: map[string]string{"TrafficMeterEnable": "1"}})
+	router.set("DeviceConfig:1#GetTrafficMeterOptions", fakeAction{code: "404"})
+	c = NewTrafficCollector("netgear", router.netgearClient(t), DefaultTrafficUnitBytes, false, nil, router.soapClient(t))
+	testutil.CollectAndCount(c)
+	testutil.CollectAndCount(c)
+	if got := router.called("DeviceConfig:1#GetTrafficMeterOptions"); got != 1 {
+		t.Errorf("got %d calls of GetTrafficMeterOptions, want 1", got)
+	}
+	if got := testutil.CollectAndCount(c, "netgear_traffic_meter_enabled"); got != 1 {
+		t.Errorf("got %d meter enabled series, want 1", got)
+	}
+}
+
+func TestTrafficMeterErrors(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{
+		"DeviceConfig:1#GetTrafficMeterEnabled":    {code: "002"},
+		"DeviceConfig:1#GetTrafficMeterStatistics": {fields: map[string]string{"TodayDownload": "1.5", "TodayUpload": "0.5"}},
+	})
+	c := NewTrafficCollector("netgear", router.netgearClient(t), DefaultTrafficUnitBytes, false, nil, router.soapClient(t))
+
+	/* A failure is an error, but the statistics are still read */
+	testutil.CollectAndCount(c)
+	if got := testutil.ToFloat64(c.lastTrafficScrapeErrorMetric); got != 1 {
+		t.Errorf("got %v for the last scrape error, want 1", got)
+	}
+	if got := router.called("DeviceConfig:1#GetTrafficMeterStatistics"); got != 1 {
+		t.Errorf("got %d calls of GetTrafficMeterStatistics, want 1", got)
+	}
+
+	/* An error is not mistaken for an unsupported action */
+	testutil.CollectAndCount(c)
+	if got := router.called("DeviceConfig:1#GetTrafficMeterEnabled"); got != 2 {
+		t.Errorf("got %d calls of GetTrafficMeterEnabled, want 2", got)
+	}
+}
+
+type statsRecorder struct {
+	stats map[string]float64
+}
+
+func (r *statsRecorder) ObserveStats(stats map[string]float64, now time.Time) {
+	r.stats = stats
+}
+
+func TestTrafficRead(t *testing.T) {
+	router := newFakeRouter(t, map[string]fakeAction{
+		"DeviceConfig:1#GetTrafficMeterStatistics": {fields: map[string]string{
+			"TodayDownload": "1.5", "TodayUpload": "0.5",
+			"YesterdayDownload": "3", "YesterdayUpload": "1",
+			"MonthDownload": "40", "MonthUpload": "10",
+		}},
+	})
+	quota, err := NewQuota("netgear", 1000, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scraped := &statsRecorder{}
+	c := NewTrafficCollector("netgear", router.netgearClient(t), DefaultTrafficUnitBytes, false, quota, nil, scraped)
+
+	/* Reads outside of scrapes only reach the observers they are given */
+	read := &statsRecorder{}
+	if err := c.Read(read); err != nil {
+		t.Fatal(err)
+	}
+	if got := read.stats["TodayDownload"]; got != 1500000 {
+		t.Errorf("got %v bytes downloaded today, want 1500000", got)
+	}
+	if scraped.stats != nil {
+		t.Error("the observers of the scrapes were handed the read")
+	}
+	if got := testutil.ToFloat64(c.trafficScrapesTotalMetric); got != 0 {
+		t.Errorf("got %v scrapes after a read, want 0", got)
+	}
+	if !quota.state.LastReadingAt.IsZero() {
+		t.Error("the quota observed a read")
+	}
+}
@@ -0,0 +1,203 @@
+// Package events turns the attached devices the router reports into a stream of
+// join, leave and IP change events.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/filters"
+)
+
+const (
+	Join     = "join"
+	Leave    = "leave"
+	IPChange = "ip_change"
+)
+
+type Event struct {
+	Type           string    `json:"type"`
+	MAC            string    `json:"mac"`
+	Name           string    `json:"name"`
+	IP             string    `json:"ip"`
+	PreviousIP     string    `json:"previous_ip,omitempty"`
+	ConnectionType string    `json:"connection_type"`
+	Time           time.Time `json:"time"`
+}
+
+/* Events are dropped for subscribers that fall this far behind */
+const subscriberBuffer = 64
+
+// Stream diffs the attached devices of successive scrapes and serves the changes to
+// HTTP subscribers as Server-Sent Events or newline delimited JSON
+type Stream struct {
+	heartbeat time.Duration
+
+	mutex       sync.Mutex
+	clients     map[string]map[string]string
+	subscribers map[chan Event]bool
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		heartbeat:   30 * time.Second,
+		subscribers: make(map[chan Event]bool),
+	}
+}
+
+// Observe compares the attached clients (GetAttachDevice fields) with the previous
+// ones and sends the differences to the subscribers. The first call only remembers
+// the clients.
+func (s *Stream) Observe(clients []map[string]string, now time.Time) {
+	current := make(map[string]map[string]string)
+	for _, client := range clients {
+		if mac := filters.NormalizeMAC(client["MACAddress"]); mac != "" {
+			current[mac] = client
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.clients != nil {
+		for _, event := range diff(s.clients, current, now) {
+			s.publish(event)
+		}
+	}
+	s.clients = current
+}
+
+/* Must be called with the mutex held */
+func (s *Stream) publish(event Event) {
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			slog.Warn("dropped event for a slow subscriber", slog.String("type", event.Type), slog.String("mac", event.MAC))
+		}
+	}
+}
+
+func diff(previous map[string]map[string]string, current map[string]map[string]string, now time.Time) []Event {
+	var events []Event
+	for mac, client := range current {
+		before, found := previous[mac]
+		switch {
+		case !found:
+			events = append(events, newEvent(Join, mac, client, now))
+		case before["IPAddress"] != client["IPAddress"]:
+			event := newEvent(IPChange, mac, client, now)
+			event.PreviousIP = before["IPAddress"]
+			events = append(events, event)
+		}
+	}
+	for mac, client := range previous {
+		if _, found := current[mac]; !found {
+			events = append(events, newEvent(Leave, mac, client, now))
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].MAC < events[j].MAC
+	})
+	return events
+}
+
+func newEvent(eventType string, mac string, client map[string]string, now time.Time) Event {
+	return Event{
+		Type:           eventType,
+		MAC:            mac,
+		Name:           client["Name"],
+		IP:             client["IPAddress"],
+		ConnectionType: client["ConnectionType"],
+		Time:           now,
+	}
+}
+
+func (s *Stream) subscribe() chan Event {
+	subscriber := make(chan Event, subscriberBuffer)
+	s.mutex.Lock()
+	s.subscribers[subscriber] = true
+	s.mutex.Unlock()
+	return subscriber
+}
+
+func (s *Stream) unsubscribe(subscriber chan Event) {
+	s.mutex.Lock()
+	delete(s.subscribers, subscriber)
+	s.mutex.Unlock()
+}
+
+// ServeHTTP streams the events until the client goes away. Server-Sent Events are sent
+// when asked for with ?format=sse or an Accept header of text/event-stream, newline
+// delimited JSON otherwise.
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	switch r.URL.Query().Get("format") {
+	case "sse":
+		sse = true
+	case "ndjson":
+		sse = false
+	case "":
+	default:
+		http.Error(w, "Unsupported format, use sse or ndjson", http.StatusBadRequest)
+		return
+	}
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	subscriber := s.subscribe()
+	defer s.unsubscribe(subscriber)
+
+	/* Proxies drop idle connections - SSE comments keep them busy */
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if sse {
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		case event := <-subscriber:
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("failed to encode event", slog.String("error", err.Error()))
+				continue
+			}
+			if sse {
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", data)
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	previous := map[string]map[string]string{
+		"AA:BB:CC:00:00:01": {"Name": "laptop", "IPAddress": "10.0.0.2"},
+		"AA:BB:CC:00:00:02": {"Name": "phone", "IPAddress": "10.0.0.3"},
+		"AA:BB:CC:00:00:03": {"Name": "tv", "IPAddress": "10.0.0.4"},
+	}
+	current := map[string]map[string]string{
+		"AA:BB:CC:00:00:01": {"Name": "laptop", "IPAddress": "10.0.0.2"},
+		"AA:BB:CC:00:00:02": {"Name": "phone", "IPAddress": "10.0.0.9"},
+		"AA:BB:CC:00:00:04": {"Name": "tablet", "IPAddress": "10.0.0.5", "ConnectionType": "5G"},
+	}
+
+	want := []Event{
+		{Type: IPChange, MAC: "AA:BB:CC:00:00:02", Name: "phone", IP: "10.0.0.9", PreviousIP: "10.0.0.3", Time: now},
+		{Type: Leave, MAC: "AA:BB:CC:00:00:03", Name: "tv", IP: "10.0.0.4", Time: now},
+		{Type: Join, MAC: "AA:BB:CC:00:00:04", Name: "tablet", IP: "10.0.0.5", ConnectionType: "5G", Time: now},
+	}
+	if got := diff(previous, current, now); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestServeNDJSON(t *testing.T) {
+	s := NewStream()
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("got content type %s", ct)
+	}
+
+	waitForSubscriber(t, s)
+	s.Observe([]map[string]string{{"MACAddress": "aa:bb:cc:00:00:01", "IPAddress": "10.0.0.2"}}, time.Now())
+	s.Observe([]map[string]string{}, time.Now())
+	s.Observe([]map[string]string{{"MACAddress": "aa:bb:cc:00:00:01", "IPAddress": "10.0.0.2"}}, time.Now())
+
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range []string{Leave, Join} {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != want || event.MAC != "AA:BB:CC:00:00:01" {
+			t.Errorf("got %+v, want a %s event", event, want)
+		}
+	}
+}
+
+func TestServeSSE(t *testing.T) {
+	s := NewStream()
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?format=sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %s", ct)
+	}
+
+	waitForSubscriber(t, s)
+	s.Observe([]map[string]string{}, time.Now())
+	s.Observe([]map[string]string{{"MACAddress": "AA:BB:CC:00:00:01"}}, time.Now())
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "event: join\n" {
+		t.Errorf("got %q", line)
+	}
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, `data: {"type":"join","mac":"AA:BB:CC:00:00:01"`) {
+		t.Errorf("got %q", line)
+	}
+}
+
+func TestServeBadFormat(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewStream().ServeHTTP(w, httptest.NewRequest("GET", "/events?format=xml", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d", w.Code)
+	}
+}
+
+func waitForSubscriber(t *testing.T, s *Stream) {
+	for i := 0; i < 100; i++ {
+		s.mutex.Lock()
+		subscribed := len(s.subscribers) > 0
+		s.mutex.Unlock()
+		if subscribed {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no subscriber")
+}
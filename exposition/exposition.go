@@ -0,0 +1,143 @@
+// Package exposition serves the metrics in the Prometheus text or the OpenMetrics format,
+// whichever the scraper asks for.
+package exposition
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+/* Base units the metric names end with, longest first so bytes_per_second wins over seconds */
+var units = [...]string{"bytes_per_second", "bytes", "seconds"}
+
+// Handler serves the metrics of the gatherer. OpenMetrics scrapes additionally get
+// # UNIT metadata for the metrics in bytes and seconds, _created samples for counters and
+// the exemplars of the scrape error counters.
+func Handler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			slog.Error("error gathering metrics", slog.String("error", err.Error()))
+			if len(families) == 0 {
+				http.Error(w, "An error has occurred while serving metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		var options []expfmt.EncoderOption
+		if format.FormatType() == expfmt.TypeOpenMetrics {
+			options = append(options, expfmt.WithUnit(), expfmt.WithCreatedLines())
+			for _, family := range families {
+				setUnit(family)
+			}
+		}
+
+		w.Header().Set("Content-Type", string(format))
+		var out io.Writer = w
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+
+		encoder := expfmt.NewEncoder(out, format, options...)
+		for _, family := range families {
+			if err := encoder.Encode(family); err != nil {
+				slog.Error("error encoding metrics", slog.String("metric", family.GetName()), slog.String("error", err.Error()))
+				return
+			}
+		}
+		/* Writes the # EOF line OpenMetrics ends with */
+		if closer, ok := encoder.(expfmt.Closer); ok {
+			closer.Close()
+		}
+	})
+}
+
+// Collectors are the running collectors by the name --filter.collectors knows them by,
+// along with the collectors that depend on them
+type Collectors map[string][]prometheus.Collector
+
+// Registry returns a new registry with only the named collectors, as listed in the
+// collect[] parameter of a scrape. Names the collectors filter does not know and
+// collectors that are not running are an error.
+func (c Collectors) Registry(names []string) (*prometheus.Registry, error) {
+	filter, err := filters.NewCollectorsFilter(names)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if _, found := c[strings.TrimSpace(name)]; !found {
+			return nil, fmt.Errorf("collector %s is not enabled by --filter.collectors", strings.TrimSpace(name))
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	for name, collectors := range c {
+		if !filter.Enabled(name) {
+			continue
+		}
+		for _, collector := range collectors {
+			if err := registry.Register(collector); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return registry, nil
+}
+
+// SelectingHandler serves the metrics of the gatherer like Handler, or only those of the
+// collectors named in the collect[] query parameters, node_exporter style:
+// /metrics?collect[]=SystemInfo&collect[]=Traffic
+func SelectingHandler(gatherer prometheus.Gatherer, collectors Collectors) http.Handler {
+	all := Handler(gatherer)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["collect[]"]
+		if len(names) == 0 {
+			all.ServeHTTP(w, r)
+			return
+		}
+
+		registry, err := collectors.Registry(names)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		Handler(registry).ServeHTTP(w, r)
+	})
+}
+
+/* OpenMetrics only allows a unit the metric name ends with, before the _total of a counter */
+func setUnit(family *dto.MetricFamily) {
+	name := family.GetName()
+	if family.GetType() == dto.MetricType_COUNTER {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(name, "_"+unit) {
+			family.Unit = &unit
+			return
+		}
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding, params, _ := strings.Cut(encoding, ";")
+		if strings.TrimSpace(encoding) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
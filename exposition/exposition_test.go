@@ -0,0 +1,171 @@
+package exposition
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	traffic := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "netgear_traffic_bytes", Help: "traffic"}, []string{"period", "direction"})
+	traffic.WithLabelValues("today", "download").Set(1500000)
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_last_traffic_scrape_duration_seconds", Help: "duration"})
+	duration.Set(0.25)
+	speed := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_client_download_bytes_per_second", Help: "speed"})
+	errors := prometheus.NewCounter(prometheus.CounterOpts{Name: "netgear_traffic_scrape_errors_total", Help: "errors"})
+	errors.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"request_id": "0123456789abcdef"})
+	registry.MustRegister(traffic, duration, speed, errors)
+	return registry
+}
+
+func scrape(t *testing.T, accept string, encoding string) (*http.Response, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Accept-Encoding", encoding)
+	rec := httptest.NewRecorder()
+	Handler(newTestRegistry()).ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func TestOpenMetrics(t *testing.T) {
+	resp, body := scrape(t, "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5", "")
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/openmetrics-text") {
+		t.Errorf("got content type %s", resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"# UNIT netgear_traffic_bytes bytes\n",
+		"# UNIT netgear_last_traffic_scrape_duration_seconds seconds\n",
+		"# UNIT netgear_client_download_bytes_per_second bytes_per_second\n",
+		"netgear_traffic_scrape_errors_total 1.0 # {request_id=\"0123456789abcdef\"} 1.0",
+		"netgear_traffic_scrape_errors_created ",
+		"# EOF\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "# UNIT netgear_traffic_scrape_errors") {
+		t.Errorf("got a unit for a counter without one\n%s", body)
+	}
+}
+
+func TestText(t *testing.T) {
+	resp, body := scrape(t, "text/plain", "gzip")
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("got content type %s", resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(body, "netgear_traffic_scrape_errors_total 1\n") {
+		t.Errorf("got\n%s", body)
+	}
+	for _, unwanted := range []string{"# UNIT", "_created", "request_id", "# EOF"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("got %q in the text format\n%s", unwanted, body)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for encoding, want := range map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, gzip;q=1": true,
+		"gzip;q=0":          false,
+		"identity":          false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		if got := acceptsGzip(req); got != want {
+			t.Errorf("got %v for %q", got, encoding)
+		}
+	}
+}
+
+func newTestCollectors() Collectors {
+	cpu := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_system_info_cpuutilization", Help: "cpu"})
+	traffic := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_traffic_bytes", Help: "traffic"})
+	quota := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_quota_used_bytes", Help: "quota"})
+	return Collectors{
+		filters.SystemInfoCollector: {cpu},
+		filters.TrafficCollector:    {traffic, quota},
+	}
+}
+
+func TestSelectingHandler(t *testing.T) {
+	running := newTestCollectors()
+	registry := prometheus.NewRegistry()
+	for _, collectors := range running {
+		registry.MustRegister(collectors...)
+	}
+	handler := SelectingHandler(registry, running)
+
+	for query, want := range map[string][]string{
+		"":                                       {"netgear_system_info_cpuutilization", "netgear_traffic_bytes", "netgear_quota_used_bytes"},
+		"collect[]=SystemInfo":                   {"netgear_system_info_cpuutilization"},
+		"collect[]=Traffic":                      {"netgear_traffic_bytes", "netgear_quota_used_bytes"},
+		"collect[]=Traffic&collect[]=Traffic":    {"netgear_traffic_bytes", "netgear_quota_used_bytes"},
+		"collect[]=SystemInfo&collect[]=Traffic": {"netgear_system_info_cpuutilization", "netgear_traffic_bytes", "netgear_quota_used_bytes"},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("got status %d for %q", rec.Code, query)
+			continue
+		}
+
+		var got []string
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			if strings.HasPrefix(line, "# TYPE ") {
+				got = append(got, strings.Fields(line)[2])
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v for %q", got, query)
+		}
+	}
+}
+
+func TestSelectingHandlerErrors(t *testing.T) {
+	handler := SelectingHandler(prometheus.NewRegistry(), newTestCollectors())
+
+	for query, want := range map[string]string{
+		"collect[]=Bogus":              "not supported",
+		"collect[]=":                   "not supported",
+		"collect[]=QoS":                "collector QoS is not enabled",
+		"collect[]=Traffic&collect[]=": "not supported",
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?"+query, nil))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("got %d %q for %q", rec.Code, rec.Body.String(), query)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ClientsFilterOptions configures which clients get per-client series. Empty
+// include lists select every client, excludes win over includes.
+type ClientsFilterOptions struct {
+	IncludeMACs            []string
+	ExcludeMACs            []string
+	IncludeName            string
+	ExcludeName            string
+	IncludeConnectionTypes []string
+	ExcludeConnectionTypes []string
+
+	// MaxSeries caps the number of clients with per-client series, 0 for no limit
+	MaxSeries int
+
+	// DropIdentityLabels removes the ip and name labels from per-client series
+	DropIdentityLabels bool
+}
+
+type ClientsFilter struct {
+	includeMACs            map[string]bool
+	excludeMACs            map[string]bool
+	includeName            *regexp.Regexp
+	excludeName            *regexp.Regexp
+	includeConnectionTypes map[string]bool
+	excludeConnectionTypes map[string]bool
+	maxSeries              int
+	dropIdentityLabels     bool
+}
+
+func NewClientsFilter(options ClientsFilterOptions) (*ClientsFilter, error) {
+	if options.MaxSeries < 0 {
+		return &ClientsFilter{}, errors.New("the maximum number of client series must not be negative")
+	}
+
+	f := &ClientsFilter{
+		includeMACs:            macSet(options.IncludeMACs),
+		excludeMACs:            macSet(options.ExcludeMACs),
+		includeConnectionTypes: connectionTypeSet(options.IncludeConnectionTypes),
+		excludeConnectionTypes: connectionTypeSet(options.ExcludeConnectionTypes),
+		maxSeries:              options.MaxSeries,
+		dropIdentityLabels:     options.DropIdentityLabels,
+	}
+
+	var err error
+	if options.IncludeName != "" {
+		if f.includeName, err = regexp.Compile(options.IncludeName); err != nil {
+			return &ClientsFilter{}, errors.New(fmt.Sprintf("Client name filter `%s` is not a valid regular expression: %s", options.IncludeName, err))
+		}
+	}
+	if options.ExcludeName != "" {
+		if f.excludeName, err = regexp.Compile(options.ExcludeName); err != nil {
+			return &ClientsFilter{}, errors.New(fmt.Sprintf("Client name filter `%s` is not a valid regular expression: %s", options.ExcludeName, err))
+		}
+	}
+
+	return f, nil
+}
+
+// Enabled reports whether the client gets per-client series. The connection type
+// "wireless" matches every client that is not wired.
+func (f *ClientsFilter) Enabled(mac string, name string, connectionType string) bool {
+	mac = NormalizeMAC(mac)
+	if f.excludeMACs[mac] || matchesConnectionType(f.excludeConnectionTypes, connectionType) {
+		return false
+	}
+	if f.excludeName != nil && f.excludeName.MatchString(name) {
+		return false
+	}
+
+	if len(f.includeMACs) > 0 && !f.includeMACs[mac] {
+		return false
+	}
+	if len(f.includeConnectionTypes) > 0 && !matchesConnectionType(f.includeConnectionTypes, connectionType) {
+		return false
+	}
+	if f.includeName != nil && !f.includeName.MatchString(name) {
+		return false
+	}
+
+	return true
+}
+
+// MaxSeries is the number of clients with per-client series, 0 for no limit
+func (f *ClientsFilter) MaxSeries() int {
+	return f.maxSeries
+}
+
+func (f *ClientsFilter) DropIdentityLabels() bool {
+	return f.dropIdentityLabels
+}
+
+// NormalizeMAC brings the MAC address spellings of routers and users
+// (aa-bb-cc-dd-ee-ff, AA:BB:CC:DD:EE:FF, ...) to the upper case colon form
+func NormalizeMAC(mac string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(mac), "-", ":"))
+}
+
+func macSet(macs []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, mac := range macs {
+		if mac = NormalizeMAC(mac); mac != "" {
+			set[mac] = true
+		}
+	}
+	return set
+}
+
+func connectionTypeSet(types []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, t := range types {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			set[t] = true
+		}
+	}
+	return set
+}
+
+func matchesConnectionType(set map[string]bool, connectionType string) bool {
+	connectionType = strings.ToLower(connectionType)
+	if set[connectionType] {
+		return true
+	}
+	return set["wireless"] && connectionType != "wired"
+}
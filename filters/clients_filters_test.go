@@ -0,0 +1,46 @@
+package filters
+
+import (
+	"testing"
+)
+
+func TestClientsFilterEnabled(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ClientsFilterOptions
+		mac     string
+		client  string
+		conn    string
+		want    bool
+	}{
+		{"no filter", ClientsFilterOptions{}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", true},
+		{"included MAC", ClientsFilterOptions{IncludeMACs: []string{"AA-BB-CC-DD-EE-FF"}}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", true},
+		{"not included MAC", ClientsFilterOptions{IncludeMACs: []string{"AA:BB:CC:DD:EE:00"}}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", false},
+		{"excluded MAC", ClientsFilterOptions{ExcludeMACs: []string{"AA:BB:CC:DD:EE:FF"}}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", false},
+		{"included name", ClientsFilterOptions{IncludeName: "^lap"}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", true},
+		{"not included name", ClientsFilterOptions{IncludeName: "^phone"}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", false},
+		{"excluded name wins", ClientsFilterOptions{IncludeName: "top", ExcludeName: "^lap"}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", false},
+		{"wireless type", ClientsFilterOptions{IncludeConnectionTypes: []string{"wireless"}}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", true},
+		{"wireless type on wired", ClientsFilterOptions{IncludeConnectionTypes: []string{"wireless"}}, "aa:bb:cc:dd:ee:ff", "nas", "wired", false},
+		{"excluded type", ClientsFilterOptions{ExcludeConnectionTypes: []string{"5g"}}, "aa:bb:cc:dd:ee:ff", "laptop", "5G", false},
+	}
+
+	for _, test := range tests {
+		f, err := NewClientsFilter(test.options)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := f.Enabled(test.mac, test.client, test.conn); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewClientsFilterErrors(t *testing.T) {
+	if _, err := NewClientsFilter(ClientsFilterOptions{IncludeName: "("}); err == nil {
+		t.Error("expected an error for an invalid name regular expression")
+	}
+	if _, err := NewClientsFilter(ClientsFilterOptions{MaxSeries: -1}); err == nil {
+		t.Error("expected an error for a negative maximum")
+	}
+}
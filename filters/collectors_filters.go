@@ -7,11 +7,22 @@ import (
 )
 
 const (
-	ClientCollector     = "Client"
-	SystemInfoCollector = "SystemInfo"
-	TrafficCollector    = "Traffic"
+	ClientCollector          = "Client"
+	ClientBandwidthCollector = "ClientBandwidth"
+	PortMappingCollector     = "PortMapping"
+	QoSCollector             = "QoS"
+	SystemInfoCollector      = "SystemInfo"
+	TrafficCollector         = "Traffic"
 )
 
+// Collectors that need SOAP actions not every firmware implements are only
+// enabled when explicitly named in the filter
+var optInCollectors = map[string]bool{
+	ClientBandwidthCollector: true,
+	PortMappingCollector:     true,
+	QoSCollector:             true,
+}
+
 type CollectorsFilter struct {
 	collectorsEnabled map[string]bool
 }
@@ -23,6 +34,12 @@ func NewCollectorsFilter(filters []string) (*CollectorsFilter, error) {
 		switch strings.Trim(collectorName, " ") {
 		case ClientCollector:
 			collectorsEnabled[ClientCollector] = true
+		case ClientBandwidthCollector:
+			collectorsEnabled[ClientBandwidthCollector] = true
+		case PortMappingCollector:
+			collectorsEnabled[PortMappingCollector] = true
+		case QoSCollector:
+			collectorsEnabled[QoSCollector] = true
 		case SystemInfoCollector:
 			collectorsEnabled[SystemInfoCollector] = true
 		case TrafficCollector:
@@ -37,7 +54,7 @@ func NewCollectorsFilter(filters []string) (*CollectorsFilter, error) {
 
 func (f *CollectorsFilter) Enabled(collectorName string) bool {
 	if len(f.collectorsEnabled) == 0 {
-		return true
+		return !optInCollectors[collectorName]
 	}
 
 	if f.collectorsEnabled[collectorName] {
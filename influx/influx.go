@@ -0,0 +1,230 @@
+// Package influx writes the router stats and clients as InfluxDB line protocol, for
+// InfluxDB and Telegraf users.
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type Config struct {
+	// Output is - for stdout, an http:// or https:// InfluxDB URL for the v2 write API,
+	// or a file to append to otherwise
+	Output string
+	Org    string
+	Bucket string
+	Token  string
+
+	Interval time.Duration
+	// Namespace of the metrics to write
+	Namespace string
+}
+
+const timeout = 10 * time.Second
+
+/* The collectors whose metrics are written, as metric name prefixes after the namespace */
+var subsystems = [...]string{"system_info_", "traffic_", "client_", "clients"}
+
+type Writer struct {
+	config     Config
+	httpClient *http.Client
+	writeURL   string
+}
+
+func New(config Config) (*Writer, error) {
+	if config.Interval <= 0 {
+		return nil, errors.New("the InfluxDB write interval must be positive")
+	}
+
+	w := &Writer{config: config}
+	if isURL(config.Output) {
+		if config.Bucket == "" {
+			return nil, errors.New("writing to InfluxDB needs a bucket")
+		}
+		u, err := url.Parse(config.Output)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+		u.RawQuery = url.Values{"org": {config.Org}, "bucket": {config.Bucket}, "precision": {"ns"}}.Encode()
+		w.writeURL = u.String()
+		w.httpClient = &http.Client{Timeout: timeout}
+	}
+	return w, nil
+}
+
+func isURL(output string) bool {
+	return strings.HasPrefix(output, "http://") || strings.HasPrefix(output, "https://")
+}
+
+func (w *Writer) Interval() time.Duration {
+	return w.config.Interval
+}
+
+// Send writes the metrics gathered for this interval
+func (w *Writer) Send(families []*dto.MetricFamily, now time.Time) {
+	if err := w.Write(families, now); err != nil {
+		slog.Error("failed to write InfluxDB line protocol", slog.String("output", w.config.Output), slog.String("error", err.Error()))
+	}
+}
+
+// Write writes the metrics to the output
+func (w *Writer) Write(families []*dto.MetricFamily, now time.Time) error {
+	lines := encode(families, w.config.Namespace, now)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	switch {
+	case w.config.Output == "-":
+		_, err := os.Stdout.Write(lines)
+		return err
+	case w.writeURL != "":
+		return w.post(lines)
+	default:
+		f, err := os.OpenFile(w.config.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(lines); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+func (w *Writer) post(lines []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.writeURL, bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.config.Token)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// encode writes one line per series of the SystemInfo, Traffic and Client metrics. The
+// measurement is the metric name, the tags are its labels and the value is in the value
+// field. Histograms get count, sum and one field per bucket upper bound.
+func encode(families []*dto.MetricFamily, namespace string, now time.Time) []byte {
+	var buf bytes.Buffer
+	for _, family := range families {
+		if !wanted(family.GetName(), namespace) {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			timestamp := now.UnixNano()
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs() * int64(time.Millisecond)
+			}
+
+			var fields []string
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				fields = appendField(fields, "value", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				fields = appendField(fields, "value", metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				fields = appendField(fields, "value", metric.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				fields = appendField(fields, "count", float64(histogram.GetSampleCount()))
+				fields = appendField(fields, "sum", histogram.GetSampleSum())
+				for _, bucket := range histogram.GetBucket() {
+					fields = appendField(fields, strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64), float64(bucket.GetCumulativeCount()))
+				}
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			buf.WriteString(escape(family.GetName(), ", "))
+			for _, tag := range tags(metric) {
+				buf.WriteString("," + tag)
+			}
+			buf.WriteString(" " + strings.Join(fields, ","))
+			buf.WriteString(" " + strconv.FormatInt(timestamp, 10) + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func wanted(name string, namespace string) bool {
+	if !strings.HasPrefix(name, namespace+"_") {
+		return false
+	}
+	name = strings.TrimPrefix(name, namespace+"_")
+	for _, subsystem := range subsystems {
+		if strings.HasPrefix(name, subsystem) {
+			return true
+		}
+	}
+	return false
+}
+
+/* Tags sorted by key, as InfluxDB recommends. Line protocol has no empty tag values, so those are left out. */
+func tags(metric *dto.Metric) []string {
+	var result []string
+	for _, label := range metric.GetLabel() {
+		if label.GetValue() == "" {
+			continue
+		}
+		result = append(result, escape(label.GetName(), tagSpecial)+"="+escape(label.GetValue(), tagSpecial))
+	}
+	sort.Strings(result)
+	return result
+}
+
+/* Line protocol has no representation for NaN and infinity */
+func appendField(fields []string, key string, value float64) []string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fields
+	}
+	return append(fields, escape(key, tagSpecial)+"="+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+/* Characters escaped in tag keys, tag values and field keys. A backslash is escaped as well so one at the end does not escape the separator after it */
+const tagSpecial = `,= \`
+
+/* Line protocol escapes its separators with a backslash and has no way to write a newline */
+func escape(s string, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r == '\n' {
+			r = ' '
+		}
+		if strings.ContainsRune(special, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
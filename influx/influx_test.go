@@ -0,0 +1,140 @@
+package influx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newTestRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+
+	cpu := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_system_info_cpuutilization", Help: "cpu"})
+	cpu.Set(12)
+	traffic := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "netgear_traffic_bytes", Help: "traffic"}, []string{"period", "direction"})
+	traffic.WithLabelValues("today", "download").Set(1500000)
+	clients := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "netgear_client_info", Help: "client"}, []string{"mac", "name", "alias"})
+	clients.WithLabelValues("AA:BB:CC:00:00:01", "Living room TV, 55\"", "").Set(1)
+	speeds := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "netgear_client_wireless_link_speed_bits_per_second", Help: "speed", Buckets: []float64{1e8, 1e9}})
+	speeds.Observe(866e6)
+	quota := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_quota_used_bytes", Help: "quota"})
+	registry.MustRegister(cpu, traffic, clients, speeds, quota)
+
+	return registry
+}
+
+func gather(t *testing.T) []*dto.MetricFamily {
+	families, err := newTestRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func TestEncode(t *testing.T) {
+	families := gather(t)
+
+	got := string(encode(families, "netgear", time.Unix(1709294400, 0)))
+	want := `netgear_client_info,mac=AA:BB:CC:00:00:01,name=Living\ room\ TV\,\ 55" value=1 1709294400000000000
+netgear_client_wireless_link_speed_bits_per_second count=1,sum=8.66e+08,1e+08=0,1e+09=1 1709294400000000000
+netgear_system_info_cpuutilization value=12 1709294400000000000
+netgear_traffic_bytes,direction=download,period=today value=1.5e+06 1709294400000000000
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  string
+	}{
+		{"Living room TV, 55\"", `Living\ room\ TV\,\ 55"`},
+		{"a=b", `a\=b`},
+		{`DESKTOP\anna`, `DESKTOP\\anna`},
+		{`trailing\`, `trailing\\`},
+		{"two\nlines", `two\ lines`},
+	} {
+		if got := escape(test.value, tagSpecial); got != test.want {
+			t.Errorf("%q: want %s, have %s", test.value, test.want, got)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "netgear.lp")
+	w, err := New(Config{Output: file, Interval: time.Minute, Namespace: "netgear"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := w.Write(gather(t), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 8 {
+		t.Errorf("got %d lines after two writes", lines)
+	}
+}
+
+func TestWriteHTTP(t *testing.T) {
+	var path, query, authorization, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		path, query, authorization, body = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	w, err := New(Config{Output: server.URL + "/", Org: "home", Bucket: "network", Token: "secret", Interval: time.Minute, Namespace: "netgear"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(gather(t), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/api/v2/write" || query != "bucket=network&org=home&precision=ns" {
+		t.Errorf("got %s?%s", path, query)
+	}
+	if authorization != "Token secret" {
+		t.Errorf("got authorization %q", authorization)
+	}
+	if !strings.Contains(body, "netgear_system_info_cpuutilization value=12 ") {
+		t.Errorf("got body %q", body)
+	}
+}
+
+func TestWriteHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"code":"unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	w, err := New(Config{Output: server.URL, Bucket: "network", Interval: time.Minute, Namespace: "netgear"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(gather(t), time.Now()); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("got error %v", err)
+	}
+
+	if _, err := New(Config{Output: server.URL, Interval: time.Minute}); err == nil {
+		t.Error("created a writer to InfluxDB without a bucket")
+	}
+}
@@ -0,0 +1,447 @@
+// Package mqtt publishes router stats and device presence to an MQTT broker with
+// Home Assistant discovery.
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/DRuggeri/netgear_exporter/tlsconfig"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type Config struct {
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSInsecure bool
+
+	// TopicPrefix is where states are published, DiscoveryPrefix where Home Assistant
+	// looks for discovery configs
+	TopicPrefix     string
+	DiscoveryPrefix string
+	NodeID          string
+	Interval        time.Duration
+
+	// ForgetAfter is how long a device without an alias is tracked after it was last
+	// seen, 0 for ever
+	ForgetAfter time.Duration
+
+	// Namespace of the metrics to publish
+	Namespace string
+}
+
+// Publisher publishes the SystemInfo and Traffic metrics as sensors and the presence of
+// devices as device trackers. Devices in the alias file are tracked, or every device
+// seen when there is no alias file until it was not seen for ForgetAfter.
+type Publisher struct {
+	config     Config
+	aliases    *aliases.Aliases
+	client     paho.Client
+	connecting paho.Token
+	timeout    time.Duration
+
+	mutex      sync.Mutex
+	attached   map[string]map[string]string
+	seen       map[string]seenDevice
+	discovered map[string]bool
+}
+
+type seenDevice struct {
+	client map[string]string
+	at     time.Time
+}
+
+/* Home Assistant MQTT discovery payload, see https://www.home-assistant.io/integrations/mqtt/#mqtt-discovery */
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	ObjectID          string          `json:"object_id,omitempty"`
+	StateTopic        string          `json:"state_topic"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	SourceType        string          `json:"source_type,omitempty"`
+	PayloadHome       string          `json:"payload_home,omitempty"`
+	PayloadNotHome    string          `json:"payload_not_home,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers  []string   `json:"identifiers"`
+	Connections  [][]string `json:"connections,omitempty"`
+	Name         string     `json:"name"`
+	Manufacturer string     `json:"manufacturer,omitempty"`
+	Model        string     `json:"model,omitempty"`
+}
+
+const (
+	online  = "online"
+	offline = "offline"
+	home    = "home"
+	notHome = "not_home"
+)
+
+func New(config Config, aliases *aliases.Aliases) (*Publisher, error) {
+	if config.Interval <= 0 {
+		return nil, errors.New("the MQTT publish interval must be positive")
+	}
+
+	p := &Publisher{
+		config:     config,
+		aliases:    aliases,
+		timeout:    10 * time.Second,
+		seen:       make(map[string]seenDevice),
+		discovered: make(map[string]bool),
+	}
+
+	options := paho.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetWill(p.availabilityTopic(), offline, 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			slog.Error("lost connection to the MQTT broker", slog.String("error", err.Error()))
+		})
+
+	if config.TLSCAFile != "" || config.TLSCertFile != "" || config.TLSInsecure {
+		tlsConfig, err := tlsconfig.New(config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile, config.TLSInsecure)
+		if err != nil {
+			return nil, err
+		}
+		options.SetTLSConfig(tlsConfig)
+	}
+
+	p.client = paho.NewClient(options)
+	return p, nil
+}
+
+/* Home Assistant forgets retained discovery configs when the broker restarts - send them again on every connect */
+func (p *Publisher) onConnect(client paho.Client) {
+	slog.Info("connected to the MQTT broker", slog.String("broker", p.config.Broker))
+
+	p.mutex.Lock()
+	p.discovered = make(map[string]bool)
+	p.mutex.Unlock()
+
+	if err := p.send(p.availabilityTopic(), online, true); err != nil {
+		slog.Error("failed to publish the availability to MQTT", slog.String("error", err.Error()))
+	}
+}
+
+// Observe remembers the attached clients (GetAttachDevice fields) for the next publish
+func (p *Publisher) Observe(clients []map[string]string, now time.Time) {
+	attached := make(map[string]map[string]string)
+	for _, client := range clients {
+		if mac := filters.NormalizeMAC(client["MACAddress"]); mac != "" {
+			attached[mac] = client
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.attached = attached
+	for mac, client := range attached {
+		p.seen[mac] = seenDevice{client: client, at: now}
+	}
+}
+
+// Connect starts connecting to the broker, retrying in the background. The broker marks
+// the exporter offline through the last will when it goes away.
+func (p *Publisher) Connect() {
+	p.connecting = p.client.Connect()
+}
+
+func (p *Publisher) Interval() time.Duration {
+	return p.config.Interval
+}
+
+// Send publishes the metrics gathered for this interval
+func (p *Publisher) Send(families []*dto.MetricFamily, now time.Time) {
+	/* Give the connection a moment before the first publish */
+	if p.connecting != nil {
+		p.connecting.WaitTimeout(p.timeout)
+	}
+	if err := p.Publish(families, now); err != nil {
+		slog.Error("failed to publish to MQTT", slog.String("error", err.Error()))
+	}
+}
+
+// Publish publishes the sensors and the device trackers. Gathering the metrics runs the
+// Client collector, so the attached clients are as current as the metrics.
+func (p *Publisher) Publish(families []*dto.MetricFamily, now time.Time) error {
+	if !p.client.IsConnected() {
+		return errors.New("not connected to the MQTT broker")
+	}
+
+	var errs []error
+	for _, s := range sensors(families, p.config.Namespace) {
+		if err := p.publishSensor(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	trackers, forgotten := p.trackers(now)
+	for _, tracker := range trackers {
+		if err := p.publishTracker(tracker); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, mac := range forgotten {
+		if err := p.forgetTracker(mac); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Publisher) publishSensor(s sensor) error {
+	stateTopic := p.topic("sensor", s.id)
+	err := p.discover("sensor", s.id, discoveryConfig{
+		Name:              s.name,
+		UniqueID:          p.config.NodeID + "_" + s.id,
+		ObjectID:          p.config.NodeID + "_" + s.id,
+		StateTopic:        stateTopic,
+		AvailabilityTopic: p.availabilityTopic(),
+		UnitOfMeasurement: s.unit,
+		DeviceClass:       s.deviceClass,
+		StateClass:        "measurement",
+		Device:            p.routerDevice(),
+	})
+	if err != nil {
+		return err
+	}
+	return p.send(stateTopic, formatValue(s.value), false)
+}
+
+type tracker struct {
+	mac  string
+	name string
+	home bool
+}
+
+/* The devices to track - the ones in the alias file, or every device seen when there is none - and the ones no longer tracked */
+func (p *Publisher) trackers(now time.Time) ([]tracker, []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	macs := p.aliases.MACs()
+	var forgotten []string
+	for mac, device := range p.seen {
+		if p.config.ForgetAfter > 0 && now.Sub(device.at) > p.config.ForgetAfter {
+			delete(p.seen, mac)
+			if len(macs) == 0 {
+				forgotten = append(forgotten, mac)
+			}
+		}
+	}
+
+	var trackers []tracker
+	if len(macs) > 0 {
+		for _, mac := range macs {
+			alias, _ := p.aliases.Lookup(mac)
+			_, attached := p.attached[mac]
+			trackers = append(trackers, tracker{mac: mac, name: alias.Alias, home: attached})
+		}
+	} else {
+		for mac, device := range p.seen {
+			_, attached := p.attached[mac]
+			trackers = append(trackers, tracker{mac: mac, name: device.client["Name"], home: attached})
+		}
+	}
+
+	sort.Slice(trackers, func(i, j int) bool {
+		return trackers[i].mac < trackers[j].mac
+	})
+	sort.Strings(forgotten)
+	return trackers, forgotten
+}
+
+func (p *Publisher) publishTracker(t tracker) error {
+	id := trackerID(t.mac)
+	name := t.name
+	if name == "" || name == "--" {
+		name = t.mac
+	}
+
+	stateTopic := p.topic("presence", id)
+	err := p.discover("device_tracker", id, discoveryConfig{
+		Name:              name,
+		UniqueID:          p.config.NodeID + "_" + id,
+		StateTopic:        stateTopic,
+		AvailabilityTopic: p.availabilityTopic(),
+		SourceType:        "router",
+		PayloadHome:       home,
+		PayloadNotHome:    notHome,
+		Device: discoveryDevice{
+			Identifiers: []string{p.config.NodeID + "_" + id},
+			Connections: [][]string{{"mac", strings.ToLower(t.mac)}},
+			Name:        name,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	state := notHome
+	if t.home {
+		state = home
+	}
+	return p.send(stateTopic, state, true)
+}
+
+/* An empty retained discovery config removes the entity from Home Assistant, an empty retained state clears it from the broker */
+func (p *Publisher) forgetTracker(mac string) error {
+	id := trackerID(mac)
+	topic := p.discoveryTopic("device_tracker", id)
+	if err := p.send(topic, "", true); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	delete(p.discovered, topic)
+	p.mutex.Unlock()
+	return p.send(p.topic("presence", id), "", true)
+}
+
+func trackerID(mac string) string {
+	return strings.ToLower(strings.ReplaceAll(mac, ":", ""))
+}
+
+/* Publish the discovery config of an entity once per connection */
+func (p *Publisher) discover(component string, id string, config discoveryConfig) error {
+	topic := p.discoveryTopic(component, id)
+
+	p.mutex.Lock()
+	done := p.discovered[topic]
+	p.mutex.Unlock()
+	if done {
+		return nil
+	}
+
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := p.send(topic, string(payload), true); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	p.discovered[topic] = true
+	p.mutex.Unlock()
+	return nil
+}
+
+func (p *Publisher) send(topic string, payload string, retained bool) error {
+	token := p.client.Publish(topic, 1, retained, payload)
+	if !token.WaitTimeout(p.timeout) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+func (p *Publisher) discoveryTopic(component string, id string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", p.config.DiscoveryPrefix, component, p.config.NodeID, id)
+}
+
+func (p *Publisher) topic(kind string, id string) string {
+	return fmt.Sprintf("%s/%s/%s", p.config.TopicPrefix, kind, id)
+}
+
+func (p *Publisher) availabilityTopic() string {
+	return p.config.TopicPrefix + "/status"
+}
+
+func (p *Publisher) routerDevice() discoveryDevice {
+	return discoveryDevice{
+		Identifiers:  []string{p.config.NodeID},
+		Name:         "Netgear router",
+		Manufacturer: "NETGEAR",
+		Model:        "netgear_exporter",
+	}
+}
+
+type sensor struct {
+	id          string
+	name        string
+	unit        string
+	deviceClass string
+	value       float64
+}
+
+/* The router stats worth a sensor: the SystemInfo and Traffic gauges apart from info and scrape metrics */
+func sensors(families []*dto.MetricFamily, namespace string) []sensor {
+	var result []sensor
+	for _, family := range families {
+		name := strings.TrimPrefix(family.GetName(), namespace+"_")
+		if family.GetType() != dto.MetricType_GAUGE || strings.HasSuffix(name, "_info") || strings.Contains(name, "scrape") {
+			continue
+		}
+		if !strings.HasPrefix(name, "system_info_") && !strings.HasPrefix(name, "traffic_") {
+			continue
+		}
+
+		unit, deviceClass := sensorUnit(name)
+		for _, metric := range family.GetMetric() {
+			id := name
+			for _, label := range metric.GetLabel() {
+				id += "_" + label.GetValue()
+			}
+			result = append(result, sensor{
+				id:          id,
+				name:        sensorName(id),
+				unit:        unit,
+				deviceClass: deviceClass,
+				value:       metric.GetGauge().GetValue(),
+			})
+		}
+	}
+	return result
+}
+
+func sensorUnit(name string) (string, string) {
+	switch {
+	case strings.HasSuffix(name, "_bytes_per_second"):
+		return "B/s", "data_rate"
+	case strings.HasSuffix(name, "_bytes"):
+		return "B", "data_size"
+	case strings.HasSuffix(name, "_seconds"):
+		return "s", "duration"
+	case strings.HasSuffix(name, "utilization"):
+		return "%", ""
+	case name == "system_info_physicalmemory" || strings.HasSuffix(name, "flash"):
+		return "MB", "data_size"
+	default:
+		return "", ""
+	}
+}
+
+/* traffic_bytes_download_today -> Traffic bytes download today */
+func sensorName(id string) string {
+	name := strings.ReplaceAll(id, "_", " ")
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,270 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/* Just enough of an MQTT 3.1.1 broker to accept a client and record what it publishes */
+type broker struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	messages map[string]message
+}
+
+type message struct {
+	payload  string
+	retained bool
+}
+
+func newBroker(t *testing.T) *broker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &broker{listener: listener, messages: make(map[string]message)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return b
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: /* CONNECT */
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: /* PUBLISH */
+			topicLength := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+topicLength])
+			rest := body[2+topicLength:]
+			var packetID []byte
+			if qos := (header >> 1) & 0x03; qos > 0 {
+				packetID, rest = rest[:2], rest[2:]
+			}
+			b.mutex.Lock()
+			b.messages[topic] = message{payload: string(rest), retained: header&0x01 == 1}
+			b.mutex.Unlock()
+			if packetID != nil {
+				conn.Write([]byte{0x40, 0x02, packetID[0], packetID[1]})
+			}
+		case 12: /* PINGREQ */
+			conn.Write([]byte{0xd0, 0x00})
+		case 14: /* DISCONNECT */
+			return
+		}
+	}
+}
+
+func (b *broker) message(topic string) (message, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	m, found := b.messages[topic]
+	return m, found
+}
+
+/* The availability is published from the connect handler, which runs in the background */
+func (b *broker) await(t *testing.T, topic string) message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if m, found := b.message(topic); found || time.Now().After(deadline) {
+			return m
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	b := newBroker(t)
+
+	registry := prometheus.NewRegistry()
+	cpu := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_system_info_cpuutilization", Help: "cpu"})
+	cpu.Set(12)
+	traffic := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "netgear_traffic_bytes", Help: "traffic"}, []string{"period", "direction"})
+	traffic.WithLabelValues("today", "download").Set(1500000)
+	scrapes := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_last_traffic_scrape_error", Help: "error"})
+	registry.MustRegister(cpu, traffic, scrapes)
+
+	aliasFile := filepath.Join(t.TempDir(), "aliases.csv")
+	if err := os.WriteFile(aliasFile, []byte("AA:BB:CC:00:00:01,Alice phone\nAA:BB:CC:00:00:02,Bob phone\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deviceAliases, err := aliases.New(aliasFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(Config{
+		Broker:          "tcp://" + b.listener.Addr().String(),
+		ClientID:        "test",
+		TopicPrefix:     "netgear_exporter",
+		DiscoveryPrefix: "homeassistant",
+		NodeID:          "netgear",
+		Interval:        time.Minute,
+		Namespace:       "netgear",
+	}, deviceAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := p.client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to connect: %v", token.Error())
+	}
+	defer p.client.Disconnect(0)
+
+	p.Observe([]map[string]string{{"MACAddress": "aa:bb:cc:00:00:01", "Name": "android-3f9a"}}, time.Now())
+	if err := p.Publish(families, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	m, found := b.message("netgear_exporter/sensor/traffic_bytes_download_today")
+	if !found || m.payload != "1500000" {
+		t.Errorf("got traffic state %+v (%v)", m, found)
+	}
+	m, found = b.message("homeassistant/sensor/netgear/system_info_cpuutilization/config")
+	if !found || !m.retained {
+		t.Fatalf("got cpu discovery %+v (%v)", m, found)
+	}
+	var config discoveryConfig
+	if err := json.Unmarshal([]byte(m.payload), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.StateTopic != "netgear_exporter/sensor/system_info_cpuutilization" || config.UnitOfMeasurement != "%" || config.AvailabilityTopic != "netgear_exporter/status" {
+		t.Errorf("got cpu discovery %+v", config)
+	}
+	if _, found := b.message("homeassistant/sensor/netgear/last_traffic_scrape_error/config"); found {
+		t.Error("published a scrape metric")
+	}
+
+	m, found = b.message("homeassistant/device_tracker/netgear/aabbcc000001/config")
+	if !found {
+		t.Fatal("no device tracker discovery for Alice")
+	}
+	if err := json.Unmarshal([]byte(m.payload), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "Alice phone" || config.StateTopic != "netgear_exporter/presence/aabbcc000001" {
+		t.Errorf("got tracker discovery %+v", config)
+	}
+	if m, _ := b.message("netgear_exporter/presence/aabbcc000001"); m.payload != "home" {
+		t.Errorf("got %q for Alice", m.payload)
+	}
+	if m, _ := b.message("netgear_exporter/presence/aabbcc000002"); m.payload != "not_home" {
+		t.Errorf("got %q for Bob", m.payload)
+	}
+	if m := b.await(t, "netgear_exporter/status"); m.payload != "online" || !m.retained {
+		t.Errorf("got availability %+v", m)
+	}
+}
+
+func TestForgetTrackers(t *testing.T) {
+	b := newBroker(t)
+	deviceAliases, _ := aliases.New("")
+
+	p, err := New(Config{
+		Broker:          "tcp://" + b.listener.Addr().String(),
+		ClientID:        "test",
+		TopicPrefix:     "netgear_exporter",
+		DiscoveryPrefix: "homeassistant",
+		NodeID:          "netgear",
+		Interval:        time.Minute,
+		ForgetAfter:     time.Hour,
+	}, deviceAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := p.client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to connect: %v", token.Error())
+	}
+	defer p.client.Disconnect(0)
+
+	guest := map[string]string{"MACAddress": "DA:A1:19:00:00:01", "Name": "guest"}
+	p.Observe([]map[string]string{guest, {"MACAddress": "AA:BB:CC:00:00:02", "Name": "laptop"}}, time.Now())
+	if err := p.Publish(nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := b.message("homeassistant/device_tracker/netgear/daa119000001/config"); m.payload == "" {
+		t.Fatal("no device tracker discovery for the guest")
+	}
+
+	/* The guest was last seen two hours ago, the laptop left just now */
+	p.Observe([]map[string]string{guest}, time.Now().Add(-2*time.Hour))
+	p.Observe(nil, time.Now())
+	if err := p.Publish(nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{"homeassistant/device_tracker/netgear/daa119000001/config", "netgear_exporter/presence/daa119000001"} {
+		if m, _ := b.message(topic); m.payload != "" || !m.retained {
+			t.Errorf("got %+v on %s, want it cleared", m, topic)
+		}
+	}
+	if m, _ := b.message("netgear_exporter/presence/aabbcc000002"); m.payload != "not_home" {
+		t.Errorf("got %q for the laptop", m.payload)
+	}
+
+	/* Once forgotten, the guest is not tracked again until it comes back */
+	b.mutex.Lock()
+	delete(b.messages, "homeassistant/device_tracker/netgear/daa119000001/config")
+	b.mutex.Unlock()
+	if err := p.Publish(nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := b.message("homeassistant/device_tracker/netgear/daa119000001/config"); found {
+		t.Error("published the forgotten guest again")
+	}
+}
+
+func TestSensorUnit(t *testing.T) {
+	tests := map[string][2]string{
+		"traffic_download_bytes_per_second": {"B/s", "data_rate"},
+		"traffic_average_bytes":             {"B", "data_size"},
+		"traffic_connection_seconds":        {"s", "duration"},
+		"system_info_memoryutilization":     {"%", ""},
+		"system_info_availableflash":        {"MB", "data_size"},
+		"traffic_meter_restart_day":         {"", ""},
+	}
+	for name, want := range tests {
+		unit, deviceClass := sensorUnit(name)
+		if unit != want[0] || deviceClass != want[1] {
+			t.Errorf("sensorUnit(%q) = %q, %q, want %q, %q", name, unit, deviceClass, want[0], want[1])
+		}
+	}
+}
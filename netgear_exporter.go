@@ -2,9 +2,16 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"log/slog"
 
@@ -14,8 +21,22 @@ import (
 	"github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/api"
 	"github.com/DRuggeri/netgear_exporter/collectors"
+	"github.com/DRuggeri/netgear_exporter/events"
+	"github.com/DRuggeri/netgear_exporter/exposition"
 	"github.com/DRuggeri/netgear_exporter/filters"
+	"github.com/DRuggeri/netgear_exporter/influx"
+	"github.com/DRuggeri/netgear_exporter/mqtt"
+	"github.com/DRuggeri/netgear_exporter/otlp"
+	"github.com/DRuggeri/netgear_exporter/oui"
+	"github.com/DRuggeri/netgear_exporter/poll"
+	"github.com/DRuggeri/netgear_exporter/pushgateway"
+	"github.com/DRuggeri/netgear_exporter/remotewrite"
+	"github.com/DRuggeri/netgear_exporter/sd"
+	"github.com/DRuggeri/netgear_exporter/soap"
+	"github.com/DRuggeri/netgear_exporter/webhook"
 )
 
 var Version = "testing"
@@ -47,10 +68,302 @@ var (
 		"clientdebug", "Print requests and responses on STDOUT. ($NETGEAR_EXPORTER_CLIENT_DEBUG)",
 	).Envar("NETGEAR_EXPORTER_CLIENT_DEBUG").Default("false").Bool()
 
+	stateDir = kingpin.Flag(
+		"state.dir", "Writable directory the exporter keeps its state files in unless their own flags are set. Defaults to the StateDirectory= of the systemd unit ($NETGEAR_EXPORTER_STATE_DIR)",
+	).Envar("NETGEAR_EXPORTER_STATE_DIR").Default("").String()
+
 	filterCollectors = kingpin.Flag(
-		"filter.collectors", "Comma separated collectors to filter (Client,SystemInfo,Traffic) ($NETGEAR_EXPORTER_FILTER_COLLECTORS)",
+		"filter.collectors", "Comma separated collectors to filter (Client,ClientBandwidth,PortMapping,QoS,SystemInfo,Traffic). ClientBandwidth, PortMapping and QoS are only enabled when listed here ($NETGEAR_EXPORTER_FILTER_COLLECTORS)",
 	).Envar("NETGEAR_EXPORTER_FILTER_COLLECTORS").Default("").String()
 
+	filterClientIncludeMac = kingpin.Flag(
+		"filter.client.include-mac", "Comma separated MAC addresses of the only clients to export per-client series for ($NETGEAR_EXPORTER_FILTER_CLIENT_INCLUDE_MAC)",
+	).Envar("NETGEAR_EXPORTER_FILTER_CLIENT_INCLUDE_MAC").Default("").String()
+
+	filterClientExcludeMac = kingpin.Flag(
+		"filter.client.exclude-mac", "Comma separated MAC addresses of clients to not export per-client series for ($NETGEAR_EXPORTER_FILTER_CLIENT_EXCLUDE_MAC)",
+	).Envar("NETGEAR_EXPORTER_FILTER_CLIENT_EXCLUDE_MAC").Default("").String()
+
+	filterClientIncludeName = kingpin.Flag(
+		"filter.client.include-name", "Regular expression the name of a client must match to export per-client series for it ($NETGEAR_EXPORTER_FILTER_CLIENT_INCLUDE_NAME)",
+	).Envar("NETGEAR_EXPORTER_FILTER_CLIENT_INCLUDE_NAME").Default("").String()
+
+	filterClientExcludeName = kingpin.Flag(
+		"filter.client.exclude-name", "Regular expression for names of clients to not export per-client series for ($NETGEAR_EXPORTER_FILTER_CLIENT_EXCLUDE_NAME)",
+	).Envar("NETGEAR_EXPORTER_FILTER_CLIENT_EXCLUDE_NAME").Default("").String()
+
+	filterClientIncludeConnectionType = kingpin.Flag(
+		"filter.client.include-connection-type", "Comma separated connection types (wired, wireless, 2.4G, 5G, ...) of the only clients to export per-client series for ($NETGEAR_EXPORTER_FILTER_CLIENT_INCLUDE_CONNECTION_TYPE)",
+	).Envar("NETGEAR_EXPORTER_FILTER_CLIENT_INCLUDE_CONNECTION_TYPE").Default("").String()
+
+	filterClientExcludeConnectionType = kingpin.Flag(
+		"filter.client.exclude-connection-type", "Comma separated connection types of clients to not export per-client series for ($NETGEAR_EXPORTER_FILTER_CLIENT_EXCLUDE_CONNECTION_TYPE)",
+	).Envar("NETGEAR_EXPORTER_FILTER_CLIENT_EXCLUDE_CONNECTION_TYPE").Default("").String()
+
+	filterClientMaxSeries = kingpin.Flag(
+		"filter.client.max-series", "Maximum number of clients to export per-client series for, 0 for no limit. Default: 0 ($NETGEAR_EXPORTER_FILTER_CLIENT_MAX_SERIES)",
+	).Envar("NETGEAR_EXPORTER_FILTER_CLIENT_MAX_SERIES").Default("0").Int()
+
+	filterClientDropIdentityLabels = kingpin.Flag(
+		"filter.client.drop-identity-labels", "Drop the ip and name labels from netgear_client_info. Default: false ($NETGEAR_EXPORTER_FILTER_CLIENT_DROP_IDENTITY_LABELS)",
+	).Envar("NETGEAR_EXPORTER_FILTER_CLIENT_DROP_IDENTITY_LABELS").Default("false").Bool()
+
+	upnpUrl = kingpin.Flag(
+		"upnp.url", "Control URL of the UPnP WANIPConnection service of the router used by the PortMapping collector. Defaults to port 5000 of the router host ($NETGEAR_EXPORTER_UPNP_URL)",
+	).Envar("NETGEAR_EXPORTER_UPNP_URL").Default("").String()
+
+	trafficUnitBytes = kingpin.Flag(
+		"traffic.unit-bytes", "Number of bytes in the unit the router reports traffic in. Netgear reports MB, set to 1000000000 for firmware reporting GB. Default: 1000000 ($NETGEAR_EXPORTER_TRAFFIC_UNIT_BYTES)",
+	).Envar("NETGEAR_EXPORTER_TRAFFIC_UNIT_BYTES").Default(strconv.FormatFloat(collectors.DefaultTrafficUnitBytes, 'f', -1, 64)).Float64()
+
+	trafficFlatMetrics = kingpin.Flag(
+		"traffic.flat-metrics", "Also export one traffic metric per router stat under the earlier names and units (netgear_traffic_todaydownload in MB, ...) for dashboards built before the period and direction labels. Default: false ($NETGEAR_EXPORTER_TRAFFIC_FLAT_METRICS)",
+	).Envar("NETGEAR_EXPORTER_TRAFFIC_FLAT_METRICS").Default("false").Bool()
+
+	trafficMeterSettings = kingpin.Flag(
+		"traffic.meter-settings", "Also read whether the traffic meter is enabled and its monthly limit on every scrape. Takes a second login session and two more calls to the router. Default: false ($NETGEAR_EXPORTER_TRAFFIC_METER_SETTINGS)",
+	).Envar("NETGEAR_EXPORTER_TRAFFIC_METER_SETTINGS").Default("false").Bool()
+
+	quotaLimitBytes = kingpin.Flag(
+		"quota.limit-bytes", "Traffic allowed by the ISP in a billing cycle. Quota tracking is enabled when this is set and needs the Traffic collector. Default: 0 ($NETGEAR_EXPORTER_QUOTA_LIMIT_BYTES)",
+	).Envar("NETGEAR_EXPORTER_QUOTA_LIMIT_BYTES").Default("0").Float64()
+
+	quotaResetDay = kingpin.Flag(
+		"quota.reset-day", "Day of the month the ISP billing cycle starts on. Default: 1 ($NETGEAR_EXPORTER_QUOTA_RESET_DAY)",
+	).Envar("NETGEAR_EXPORTER_QUOTA_RESET_DAY").Default("1").Int()
+
+	quotaStateFile = kingpin.Flag(
+		"quota.state-file", "File the traffic used in the current billing cycle is saved to. Default: netgear_exporter_quota.json in --state.dir ($NETGEAR_EXPORTER_QUOTA_STATE_FILE)",
+	).Envar("NETGEAR_EXPORTER_QUOTA_STATE_FILE").Default("").String()
+
+	clientDetailed = kingpin.Flag(
+		"client.detailed", "Read attached devices with GetAttachDevice2 to export SSID, band, access point, device type and allow/block status of clients. Needs newer firmware. Default: false ($NETGEAR_EXPORTER_CLIENT_DETAILED)",
+	).Envar("NETGEAR_EXPORTER_CLIENT_DETAILED").Default("false").Bool()
+
+	clientOuiFile = kingpin.Flag(
+		"client.oui-file", "IEEE OUI registry (oui.csv or oui.txt) to resolve client vendors with in addition to the embedded one, which only holds common vendors. Reloaded on SIGHUP ($NETGEAR_EXPORTER_CLIENT_OUI_FILE)",
+	).Envar("NETGEAR_EXPORTER_CLIENT_OUI_FILE").Default("").String()
+
+	clientAliasFile = kingpin.Flag(
+		"client.alias-file", "CSV file with MAC address, alias, owner and group of clients. Reloaded on SIGHUP ($NETGEAR_EXPORTER_CLIENT_ALIAS_FILE)",
+	).Envar("NETGEAR_EXPORTER_CLIENT_ALIAS_FILE").Default("").String()
+
+	clientMergeRandomized = kingpin.Flag(
+		"client.merge-randomized", "Track clients with randomized MAC addresses by name, under the MAC address they were first seen with, in the events, service discovery, JSON API and MQTT. Default: false ($NETGEAR_EXPORTER_CLIENT_MERGE_RANDOMIZED)",
+	).Envar("NETGEAR_EXPORTER_CLIENT_MERGE_RANDOMIZED").Default("false").Bool()
+
+	clientMergeForgetAfterDays = kingpin.Flag(
+		"client.merge-forget-after-days", "Days after which a name merged by --client.merge-randomized that was not seen again is forgotten, so the next device with that name is tracked under its own MAC address. 0 remembers every name. Default: 30 ($NETGEAR_EXPORTER_CLIENT_MERGE_FORGET_AFTER_DAYS)",
+	).Envar("NETGEAR_EXPORTER_CLIENT_MERGE_FORGET_AFTER_DAYS").Default("30").Int()
+
+	unknownDetect = kingpin.Flag(
+		"unknown.detect", "Flag clients that are not approved in netgear_client_unknown and notify the webhooks when they first connect. Needs the Client collector. Default: false ($NETGEAR_EXPORTER_UNKNOWN_DETECT)",
+	).Envar("NETGEAR_EXPORTER_UNKNOWN_DETECT").Default("false").Bool()
+
+	unknownApprovedFile = kingpin.Flag(
+		"unknown.approved-file", "File with the MAC addresses of approved clients, one per line. Clients in the alias file are approved as well. Without this file, the clients attached when the exporter first runs are approved. Reloaded on SIGHUP ($NETGEAR_EXPORTER_UNKNOWN_APPROVED_FILE)",
+	).Envar("NETGEAR_EXPORTER_UNKNOWN_APPROVED_FILE").Default("").String()
+
+	unknownStateFile = kingpin.Flag(
+		"unknown.state-file", "File the clients seen so far are saved to. Default: netgear_exporter_devices.json in --state.dir ($NETGEAR_EXPORTER_UNKNOWN_STATE_FILE)",
+	).Envar("NETGEAR_EXPORTER_UNKNOWN_STATE_FILE").Default("").String()
+
+	unknownForgetAfterDays = kingpin.Flag(
+		"unknown.forget-after-days", "Days after which clients that were not seen again are removed from the state file, except the ones approved on the first inventory. 0 keeps every client. Default: 90 ($NETGEAR_EXPORTER_UNKNOWN_FORGET_AFTER_DAYS)",
+	).Envar("NETGEAR_EXPORTER_UNKNOWN_FORGET_AFTER_DAYS").Default("90").Int()
+
+	unknownWebhookUrls = kingpin.Flag(
+		"unknown.webhook-url", "URL to post a JSON notification about an unknown client to. Can be repeated ($NETGEAR_EXPORTER_UNKNOWN_WEBHOOK_URL)",
+	).Envar("NETGEAR_EXPORTER_UNKNOWN_WEBHOOK_URL").Strings()
+
+	unknownSlackWebhookUrls = kingpin.Flag(
+		"unknown.slack-webhook-url", "Slack incoming webhook URL to post a message about an unknown client to. Can be repeated ($NETGEAR_EXPORTER_UNKNOWN_SLACK_WEBHOOK_URL)",
+	).Envar("NETGEAR_EXPORTER_UNKNOWN_SLACK_WEBHOOK_URL").Strings()
+
+	unknownWebhookRetries = kingpin.Flag(
+		"unknown.webhook-retries", "Number of times a failed webhook notification is retried with an increasing delay. Default: 3 ($NETGEAR_EXPORTER_UNKNOWN_WEBHOOK_RETRIES)",
+	).Envar("NETGEAR_EXPORTER_UNKNOWN_WEBHOOK_RETRIES").Default("3").Int()
+
+	mqttBroker = kingpin.Flag(
+		"mqtt.broker", "MQTT broker to publish stats and client presence to with Home Assistant discovery, such as tcp://localhost:1883 or ssl://broker:8883. Disabled when empty ($NETGEAR_EXPORTER_MQTT_BROKER)",
+	).Envar("NETGEAR_EXPORTER_MQTT_BROKER").String()
+
+	mqttClientId = kingpin.Flag(
+		"mqtt.client-id", "MQTT client ID. Default: netgear_exporter ($NETGEAR_EXPORTER_MQTT_CLIENT_ID)",
+	).Envar("NETGEAR_EXPORTER_MQTT_CLIENT_ID").Default("netgear_exporter").String()
+
+	mqttUsername = kingpin.Flag(
+		"mqtt.username", "MQTT username ($NETGEAR_EXPORTER_MQTT_USERNAME). The password must be set in the environment variable NETGEAR_EXPORTER_MQTT_PASSWORD",
+	).Envar("NETGEAR_EXPORTER_MQTT_USERNAME").String()
+
+	mqttTlsCaFile = kingpin.Flag(
+		"mqtt.tls.ca-file", "CA certificate (PEM format) to verify the MQTT broker with ($NETGEAR_EXPORTER_MQTT_TLS_CA_FILE)",
+	).Envar("NETGEAR_EXPORTER_MQTT_TLS_CA_FILE").ExistingFile()
+
+	mqttTlsCertFile = kingpin.Flag(
+		"mqtt.tls.cert-file", "Client certificate (PEM format) to authenticate to the MQTT broker with ($NETGEAR_EXPORTER_MQTT_TLS_CERT_FILE)",
+	).Envar("NETGEAR_EXPORTER_MQTT_TLS_CERT_FILE").ExistingFile()
+
+	mqttTlsKeyFile = kingpin.Flag(
+		"mqtt.tls.key-file", "Private key (PEM format) of the client certificate ($NETGEAR_EXPORTER_MQTT_TLS_KEY_FILE)",
+	).Envar("NETGEAR_EXPORTER_MQTT_TLS_KEY_FILE").ExistingFile()
+
+	mqttTlsInsecure = kingpin.Flag(
+		"mqtt.tls.insecure", "Skip verifying the certificate of the MQTT broker. Default: false ($NETGEAR_EXPORTER_MQTT_TLS_INSECURE)",
+	).Envar("NETGEAR_EXPORTER_MQTT_TLS_INSECURE").Default("false").Bool()
+
+	mqttTopicPrefix = kingpin.Flag(
+		"mqtt.topic-prefix", "Prefix of the topics states are published to. Default: netgear_exporter ($NETGEAR_EXPORTER_MQTT_TOPIC_PREFIX)",
+	).Envar("NETGEAR_EXPORTER_MQTT_TOPIC_PREFIX").Default("netgear_exporter").String()
+
+	mqttDiscoveryPrefix = kingpin.Flag(
+		"mqtt.discovery-prefix", "Home Assistant discovery prefix. Default: homeassistant ($NETGEAR_EXPORTER_MQTT_DISCOVERY_PREFIX)",
+	).Envar("NETGEAR_EXPORTER_MQTT_DISCOVERY_PREFIX").Default("homeassistant").String()
+
+	mqttNodeId = kingpin.Flag(
+		"mqtt.node-id", "Node ID the Home Assistant entities are grouped under. Default: netgear ($NETGEAR_EXPORTER_MQTT_NODE_ID)",
+	).Envar("NETGEAR_EXPORTER_MQTT_NODE_ID").Default("netgear").String()
+
+	mqttInterval = kingpin.Flag(
+		"mqtt.interval", "Seconds between publishes to MQTT. Default: 60 ($NETGEAR_EXPORTER_MQTT_INTERVAL)",
+	).Envar("NETGEAR_EXPORTER_MQTT_INTERVAL").Default("60").Int()
+
+	mqttForgetAfterDays = kingpin.Flag(
+		"mqtt.forget-after-days", "Days after which devices that were not seen again are removed from Home Assistant when there is no alias file. 0 keeps every device. Default: 7 ($NETGEAR_EXPORTER_MQTT_FORGET_AFTER_DAYS)",
+	).Envar("NETGEAR_EXPORTER_MQTT_FORGET_AFTER_DAYS").Default("7").Int()
+
+	pushUrl = kingpin.Flag(
+		"push.url", "Pushgateway to push the metrics to on every interval, for when Prometheus cannot reach the exporter. Disabled when empty ($NETGEAR_EXPORTER_PUSH_URL)",
+	).Envar("NETGEAR_EXPORTER_PUSH_URL").String()
+
+	pushJob = kingpin.Flag(
+		"push.job", "Job the pushed metrics are grouped under. Default: netgear_exporter ($NETGEAR_EXPORTER_PUSH_JOB)",
+	).Envar("NETGEAR_EXPORTER_PUSH_JOB").Default("netgear_exporter").String()
+
+	pushGrouping = kingpin.Flag(
+		"push.grouping", "Grouping label of the pushed metrics as name=value. Can be repeated. The instance label defaults to the router host ($NETGEAR_EXPORTER_PUSH_GROUPING)",
+	).Envar("NETGEAR_EXPORTER_PUSH_GROUPING").StringMap()
+
+	pushUsername = kingpin.Flag(
+		"push.username", "Username for Pushgateway basic auth ($NETGEAR_EXPORTER_PUSH_USERNAME). The password must be set in the environment variable NETGEAR_EXPORTER_PUSH_PASSWORD",
+	).Envar("NETGEAR_EXPORTER_PUSH_USERNAME").String()
+
+	pushTlsCaFile = kingpin.Flag(
+		"push.tls.ca-file", "CA certificate (PEM format) to verify the Pushgateway with ($NETGEAR_EXPORTER_PUSH_TLS_CA_FILE)",
+	).Envar("NETGEAR_EXPORTER_PUSH_TLS_CA_FILE").ExistingFile()
+
+	pushTlsCertFile = kingpin.Flag(
+		"push.tls.cert-file", "Client certificate (PEM format) to authenticate to the Pushgateway with ($NETGEAR_EXPORTER_PUSH_TLS_CERT_FILE)",
+	).Envar("NETGEAR_EXPORTER_PUSH_TLS_CERT_FILE").ExistingFile()
+
+	pushTlsKeyFile = kingpin.Flag(
+		"push.tls.key-file", "Private key (PEM format) of the client certificate ($NETGEAR_EXPORTER_PUSH_TLS_KEY_FILE)",
+	).Envar("NETGEAR_EXPORTER_PUSH_TLS_KEY_FILE").ExistingFile()
+
+	pushTlsInsecure = kingpin.Flag(
+		"push.tls.insecure", "Skip verifying the certificate of the Pushgateway. Default: false ($NETGEAR_EXPORTER_PUSH_TLS_INSECURE)",
+	).Envar("NETGEAR_EXPORTER_PUSH_TLS_INSECURE").Default("false").Bool()
+
+	pushInterval = kingpin.Flag(
+		"push.interval", "Seconds between pushes. Default: 60 ($NETGEAR_EXPORTER_PUSH_INTERVAL)",
+	).Envar("NETGEAR_EXPORTER_PUSH_INTERVAL").Default("60").Int()
+
+	pushRetries = kingpin.Flag(
+		"push.retries", "Number of times a failed push is retried with an increasing delay. Default: 3 ($NETGEAR_EXPORTER_PUSH_RETRIES)",
+	).Envar("NETGEAR_EXPORTER_PUSH_RETRIES").Default("3").Int()
+
+	remoteWriteUrl = kingpin.Flag(
+		"remote-write.url", "Prometheus remote write endpoint to send the metrics to on every interval, for sites without a Prometheus to scrape the exporter. Disabled when empty ($NETGEAR_EXPORTER_REMOTE_WRITE_URL)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_URL").String()
+
+	remoteWriteLabels = kingpin.Flag(
+		"remote-write.label", "Label added to every series sent as name=value. Can be repeated. The job label defaults to netgear_exporter and the instance label to the router host ($NETGEAR_EXPORTER_REMOTE_WRITE_LABEL)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_LABEL").StringMap()
+
+	remoteWriteUsername = kingpin.Flag(
+		"remote-write.username", "Username for remote write basic auth ($NETGEAR_EXPORTER_REMOTE_WRITE_USERNAME). The password must be set in the environment variable NETGEAR_EXPORTER_REMOTE_WRITE_PASSWORD",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_USERNAME").String()
+
+	remoteWriteTlsCaFile = kingpin.Flag(
+		"remote-write.tls.ca-file", "CA certificate (PEM format) to verify the remote write endpoint with ($NETGEAR_EXPORTER_REMOTE_WRITE_TLS_CA_FILE)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_TLS_CA_FILE").ExistingFile()
+
+	remoteWriteTlsCertFile = kingpin.Flag(
+		"remote-write.tls.cert-file", "Client certificate (PEM format) to authenticate to the remote write endpoint with ($NETGEAR_EXPORTER_REMOTE_WRITE_TLS_CERT_FILE)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_TLS_CERT_FILE").ExistingFile()
+
+	remoteWriteTlsKeyFile = kingpin.Flag(
+		"remote-write.tls.key-file", "Private key (PEM format) of the client certificate ($NETGEAR_EXPORTER_REMOTE_WRITE_TLS_KEY_FILE)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_TLS_KEY_FILE").ExistingFile()
+
+	remoteWriteTlsInsecure = kingpin.Flag(
+		"remote-write.tls.insecure", "Skip verifying the certificate of the remote write endpoint. Default: false ($NETGEAR_EXPORTER_REMOTE_WRITE_TLS_INSECURE)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_TLS_INSECURE").Default("false").Bool()
+
+	remoteWriteInterval = kingpin.Flag(
+		"remote-write.interval", "Seconds between collecting and sending the metrics. Default: 60 ($NETGEAR_EXPORTER_REMOTE_WRITE_INTERVAL)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_INTERVAL").Default("60").Int()
+
+	remoteWriteQueueDir = kingpin.Flag(
+		"remote-write.queue-dir", "Directory the batches that could not be sent yet are kept in. Default: netgear_exporter_wal in --state.dir ($NETGEAR_EXPORTER_REMOTE_WRITE_QUEUE_DIR)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_QUEUE_DIR").Default("").String()
+
+	remoteWriteQueueMaxBatches = kingpin.Flag(
+		"remote-write.queue-max-batches", "Number of batches kept while the endpoint cannot be reached before the oldest are dropped. Default: 1440 ($NETGEAR_EXPORTER_REMOTE_WRITE_QUEUE_MAX_BATCHES)",
+	).Envar("NETGEAR_EXPORTER_REMOTE_WRITE_QUEUE_MAX_BATCHES").Default("1440").Int()
+
+	influxOutput = kingpin.Flag(
+		"influx.output", "Where to write the SystemInfo, Traffic and Client metrics as InfluxDB line protocol on every interval: - for stdout, an http(s):// InfluxDB URL for the v2 write API, or a file to append to. Disabled when empty ($NETGEAR_EXPORTER_INFLUX_OUTPUT)",
+	).Envar("NETGEAR_EXPORTER_INFLUX_OUTPUT").String()
+
+	influxOrg = kingpin.Flag(
+		"influx.org", "InfluxDB organization to write to ($NETGEAR_EXPORTER_INFLUX_ORG). The API token must be set in the environment variable NETGEAR_EXPORTER_INFLUX_TOKEN",
+	).Envar("NETGEAR_EXPORTER_INFLUX_ORG").String()
+
+	influxBucket = kingpin.Flag(
+		"influx.bucket", "InfluxDB bucket to write to. Required for an InfluxDB URL ($NETGEAR_EXPORTER_INFLUX_BUCKET)",
+	).Envar("NETGEAR_EXPORTER_INFLUX_BUCKET").String()
+
+	influxInterval = kingpin.Flag(
+		"influx.interval", "Seconds between writes of line protocol. Default: 60 ($NETGEAR_EXPORTER_INFLUX_INTERVAL)",
+	).Envar("NETGEAR_EXPORTER_INFLUX_INTERVAL").Default("60").Int()
+
+	otlpEndpoint = kingpin.Flag(
+		"otlp.endpoint", "OpenTelemetry collector to export the metrics to over OTLP on every interval, as http(s)://host:port. For gRPC, http:// connects without TLS. Disabled when empty ($NETGEAR_EXPORTER_OTLP_ENDPOINT)",
+	).Envar("NETGEAR_EXPORTER_OTLP_ENDPOINT").String()
+
+	otlpProtocol = kingpin.Flag(
+		"otlp.protocol", "OTLP protocol to export with, grpc or http. Default: grpc ($NETGEAR_EXPORTER_OTLP_PROTOCOL)",
+	).Envar("NETGEAR_EXPORTER_OTLP_PROTOCOL").Default("grpc").Enum("grpc", "http")
+
+	otlpHeaders = kingpin.Flag(
+		"otlp.header", "Header sent with every export as name=value, for example for authentication. Can be repeated ($NETGEAR_EXPORTER_OTLP_HEADER)",
+	).Envar("NETGEAR_EXPORTER_OTLP_HEADER").StringMap()
+
+	otlpResourceAttributes = kingpin.Flag(
+		"otlp.resource-attribute", "Resource attribute added to the exported metrics as name=value. Can be repeated ($NETGEAR_EXPORTER_OTLP_RESOURCE_ATTRIBUTE)",
+	).Envar("NETGEAR_EXPORTER_OTLP_RESOURCE_ATTRIBUTE").StringMap()
+
+	otlpTlsCaFile = kingpin.Flag(
+		"otlp.tls.ca-file", "CA certificate (PEM format) to verify the OpenTelemetry collector with ($NETGEAR_EXPORTER_OTLP_TLS_CA_FILE)",
+	).Envar("NETGEAR_EXPORTER_OTLP_TLS_CA_FILE").ExistingFile()
+
+	otlpTlsCertFile = kingpin.Flag(
+		"otlp.tls.cert-file", "Client certificate (PEM format) to authenticate to the OpenTelemetry collector with ($NETGEAR_EXPORTER_OTLP_TLS_CERT_FILE)",
+	).Envar("NETGEAR_EXPORTER_OTLP_TLS_CERT_FILE").ExistingFile()
+
+	otlpTlsKeyFile = kingpin.Flag(
+		"otlp.tls.key-file", "Private key (PEM format) of the client certificate ($NETGEAR_EXPORTER_OTLP_TLS_KEY_FILE)",
+	).Envar("NETGEAR_EXPORTER_OTLP_TLS_KEY_FILE").ExistingFile()
+
+	otlpTlsInsecure = kingpin.Flag(
+		"otlp.tls.insecure", "Skip verifying the certificate of the OpenTelemetry collector. Default: false ($NETGEAR_EXPORTER_OTLP_TLS_INSECURE)",
+	).Envar("NETGEAR_EXPORTER_OTLP_TLS_INSECURE").Default("false").Bool()
+
+	otlpInterval = kingpin.Flag(
+		"otlp.interval", "Seconds between exports over OTLP. Default: 60 ($NETGEAR_EXPORTER_OTLP_INTERVAL)",
+	).Envar("NETGEAR_EXPORTER_OTLP_INTERVAL").Default("60").Int()
+
 	metricsNamespace = kingpin.Flag(
 		"metrics.namespace", "Metrics Namespace ($NETGEAR_EXPORTER_METRICS_NAMESPACE)",
 	).Envar("NETGEAR_EXPORTER_METRICS_NAMESPACE").Default("netgear").String()
@@ -63,6 +376,22 @@ var (
 		"web.telemetry-path", "Path under which to expose Prometheus metrics ($NETGEAR_EXPORTER_WEB_TELEMETRY_PATH)",
 	).Envar("NETGEAR_EXPORTER_WEB_TELEMETRY_PATH").Default("/metrics").String()
 
+	eventsPath = kingpin.Flag(
+		"web.events-path", "Path under which to stream client join, leave and IP change events. Needs the Client collector ($NETGEAR_EXPORTER_WEB_EVENTS_PATH)",
+	).Envar("NETGEAR_EXPORTER_WEB_EVENTS_PATH").Default("/events").String()
+
+	sdPath = kingpin.Flag(
+		"web.sd-path", "Path under which to serve the attached clients as Prometheus HTTP service discovery targets. Needs the Client collector ($NETGEAR_EXPORTER_WEB_SD_PATH)",
+	).Envar("NETGEAR_EXPORTER_WEB_SD_PATH").Default("/sd").String()
+
+	apiPath = kingpin.Flag(
+		"web.api-path", "Path under which to serve the clients, system info and traffic of the last scrape as JSON ($NETGEAR_EXPORTER_WEB_API_PATH)",
+	).Envar("NETGEAR_EXPORTER_WEB_API_PATH").Default("/api/v1").String()
+
+	maxDataAge = kingpin.Flag(
+		"web.max-data-age", "Seconds after which the JSON API and service discovery read the router again instead of serving the data of the last scrape. 0 only serves the data of the last scrape. Default: 60 ($NETGEAR_EXPORTER_WEB_MAX_DATA_AGE)",
+	).Envar("NETGEAR_EXPORTER_WEB_MAX_DATA_AGE").Default("60").Int()
+
 	authUsername = kingpin.Flag(
 		"web.auth.username", "Username for web interface basic auth ($NETGEAR_EXPORTER_WEB_AUTH_USERNAME). The password must be set in the environment variable NETGEAR_EXPORTER_WEB_AUTH_PASSWORD",
 	).Envar("NETGEAR_EXPORTER_WEB_AUTH_USERNAME").String()
@@ -116,12 +445,15 @@ func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.handler(w, r)
 }
 
-func prometheusHandler() http.Handler {
-	handler := promhttp.Handler()
+func prometheusHandler(running exposition.Collectors) http.Handler {
+	return authHandler(promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, exposition.SelectingHandler(prometheus.DefaultGatherer, running)))
+}
 
+/* Protect an endpoint with the same credentials as the metrics */
+func authHandler(handler http.Handler) http.Handler {
 	if *authUsername != "" && authPassword != "" {
-		handler = &basicAuthHandler{
-			handler:  promhttp.Handler().ServeHTTP,
+		return &basicAuthHandler{
+			handler:  handler.ServeHTTP,
 			username: *authUsername,
 			password: authPassword,
 		}
@@ -130,6 +462,66 @@ func prometheusHandler() http.Handler {
 	return handler
 }
 
+/* Netgear routers serve the UPnP IGD control endpoint over plain HTTP on port 5000 */
+func defaultUPnPUrl(routerUrl string) (string, error) {
+	host, err := routerHost(routerUrl)
+	if err != nil {
+		return "", err
+	}
+	return "http://" + net.JoinHostPort(host, "5000") + "/Public_UPNP_C3", nil
+}
+
+/* The host name or IP address of the router, which is also given without a scheme */
+func routerHost(routerUrl string) (string, error) {
+	if !strings.Contains(routerUrl, "://") {
+		routerUrl = "https://" + routerUrl
+	}
+	u, err := url.Parse(routerUrl)
+	if err != nil {
+		return "", err
+	}
+	return u.Hostname(), nil
+}
+
+/* Split a comma separated flag value, an empty value is an empty list */
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+/* Reload a file the exporter reads whenever it receives SIGHUP. A broken file keeps the previous contents in use */
+func reloadOnHangup(name string, reload func() error) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := reload(); err != nil {
+				slog.Error("failed to reload "+name, slog.String("error", err.Error()))
+			} else {
+				slog.Info("reloaded " + name)
+			}
+		}
+	}()
+}
+
+/* The path of a state file: the flag when it is set, or the name in --state.dir. Never the working directory, which is often not writable under systemd or in a container */
+func statePath(flag string, path string, name string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	dir := *stateDir
+	if dir == "" {
+		/* systemd sets this to the StateDirectory= of the unit, a colon separated list */
+		dir, _, _ = strings.Cut(os.Getenv("STATE_DIRECTORY"), ":")
+	}
+	if dir == "" {
+		return "", fmt.Errorf("--%s or --state.dir must be set", flag)
+	}
+	return filepath.Join(dir, name), nil
+}
+
 func main() {
 	kingpin.Version(Version)
 	kingpin.HelpFlag.Short('h')
@@ -154,13 +546,38 @@ func main() {
 		   - Call the describe function to feed the channel (which blocks until the consume function eats a message)
 		   - When the describe function exits after returning the last item, close the channel to end the background consume function
 		*/
+		clientsFilter, _ := filters.NewClientsFilter(filters.ClientsFilterOptions{})
+		vendors, _ := oui.New("")
+		deviceAliases, _ := aliases.New("")
+
 		fmt.Println("Client")
-		clientCollector := collectors.NewClientCollector(*metricsNamespace, nil)
+		clientCollector := collectors.NewClientCollector(*metricsNamespace, nil, true, clientsFilter, vendors, deviceAliases)
 		out = make(chan *prometheus.Desc)
 		go eatOutput(out)
 		clientCollector.Describe(out)
 		close(out)
 
+		fmt.Println("ClientBandwidth")
+		clientBandwidthCollector := collectors.NewClientBandwidthCollector(*metricsNamespace, nil, *trafficUnitBytes, clientsFilter)
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		clientBandwidthCollector.Describe(out)
+		close(out)
+
+		fmt.Println("PortMapping")
+		portMappingCollector := collectors.NewPortMappingCollector(*metricsNamespace, nil, nil)
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		portMappingCollector.Describe(out)
+		close(out)
+
+		fmt.Println("QoS")
+		qosCollector := collectors.NewQoSCollector(*metricsNamespace, nil)
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		qosCollector.Describe(out)
+		close(out)
+
 		fmt.Println("SystemInfo")
 		systemInfoCollector := collectors.NewSystemInfoCollector(*metricsNamespace, nil)
 		out = make(chan *prometheus.Desc)
@@ -169,12 +586,26 @@ func main() {
 		close(out)
 
 		fmt.Println("Traffic")
-		trafficCollector := collectors.NewTrafficCollector(*metricsNamespace, nil)
+		trafficCollector := collectors.NewTrafficCollector(*metricsNamespace, nil, *trafficUnitBytes, *trafficFlatMetrics, nil, nil)
 		out = make(chan *prometheus.Desc)
 		go eatOutput(out)
 		trafficCollector.Describe(out)
 		close(out)
 
+		fmt.Println("Unknown")
+		inventory, _ := collectors.NewInventory(*metricsNamespace, "", deviceAliases, nil, "", 0)
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		inventory.Describe(out)
+		close(out)
+
+		fmt.Println("Quota")
+		quota, _ := collectors.NewQuota(*metricsNamespace, 0, 1, "")
+		out = make(chan *prometheus.Desc)
+		go eatOutput(out)
+		quota.Describe(out)
+		close(out)
+
 		os.Exit(0)
 	}
 
@@ -212,6 +643,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *trafficUnitBytes <= 0 {
+		slog.Error("the traffic unit must be a positive number of bytes", slog.Float64("traffic.unit-bytes", *trafficUnitBytes))
+		os.Exit(1)
+	}
+
 	var collectorsFilters []string
 	if *filterCollectors != "" {
 		collectorsFilters = strings.Split(*filterCollectors, ",")
@@ -222,23 +658,357 @@ func main() {
 		os.Exit(1)
 	}
 
+	clientsFilter, err := filters.NewClientsFilter(filters.ClientsFilterOptions{
+		IncludeMACs:            splitList(*filterClientIncludeMac),
+		ExcludeMACs:            splitList(*filterClientExcludeMac),
+		IncludeName:            *filterClientIncludeName,
+		ExcludeName:            *filterClientExcludeName,
+		IncludeConnectionTypes: splitList(*filterClientIncludeConnectionType),
+		ExcludeConnectionTypes: splitList(*filterClientExcludeConnectionType),
+		MaxSeries:              *filterClientMaxSeries,
+		DropIdentityLabels:     *filterClientDropIdentityLabels,
+	})
+	if err != nil {
+		slog.Error("failed to create clients filter", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	if *unknownDetect && !collectorsFilter.Enabled(filters.ClientCollector) {
+		slog.Error("unknown client detection needs the Client collector")
+		os.Exit(1)
+	}
+
+	deviceAliases, err := aliases.New(*clientAliasFile)
+	if err != nil {
+		slog.Error("failed to load the alias file", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if *clientAliasFile != "" {
+		reloadOnHangup("alias file", deviceAliases.Reload)
+	}
+
+	var publisher *mqtt.Publisher
+	if *mqttBroker != "" {
+		publisher, err = mqtt.New(mqtt.Config{
+			Broker:          *mqttBroker,
+			ClientID:        *mqttClientId,
+			Username:        *mqttUsername,
+			Password:        os.Getenv("NETGEAR_EXPORTER_MQTT_PASSWORD"),
+			TLSCAFile:       *mqttTlsCaFile,
+			TLSCertFile:     *mqttTlsCertFile,
+			TLSKeyFile:      *mqttTlsKeyFile,
+			TLSInsecure:     *mqttTlsInsecure,
+			TopicPrefix:     *mqttTopicPrefix,
+			DiscoveryPrefix: *mqttDiscoveryPrefix,
+			NodeID:          *mqttNodeId,
+			Interval:        time.Duration(*mqttInterval) * time.Second,
+			ForgetAfter:     time.Duration(*mqttForgetAfterDays) * 24 * time.Hour,
+			Namespace:       *metricsNamespace,
+		}, deviceAliases)
+		if err != nil {
+			slog.Error("failed to set up MQTT publishing", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	/* The collectors by filter name, for scrapes that select some with collect[] */
+	running := make(exposition.Collectors)
+	maxAge := time.Duration(*maxDataAge) * time.Second
+
+	var eventStream *events.Stream
+	var sdTargets *sd.Targets
+	var apiClients *api.Clients
+	var apiSystem, apiTraffic *api.Stats
 	if collectorsFilter.Enabled(filters.ClientCollector) {
-		clientCollector := collectors.NewClientCollector(*metricsNamespace, netgearClient)
+		vendors, err := oui.New(*clientOuiFile)
+		if err != nil {
+			slog.Error("failed to load the OUI database", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		slog.Debug("loaded the OUI database", slog.Int("assignments", vendors.Len()))
+		if *clientOuiFile != "" {
+			reloadOnHangup("OUI database", vendors.Reload)
+		}
+
+		eventStream = events.NewStream()
+		sdTargets = sd.NewTargets(deviceAliases)
+		apiClients = api.NewClients(vendors, deviceAliases)
+		observers := []collectors.ClientObserver{eventStream, sdTargets, apiClients}
+		if publisher != nil {
+			observers = append(observers, publisher)
+		}
+		/* Unknown client detection keeps the real addresses, a name is easily taken over */
+		if *clientMergeRandomized {
+			observers = []collectors.ClientObserver{collectors.NewRandomizedMerger(time.Duration(*clientMergeForgetAfterDays)*24*time.Hour, observers...)}
+		}
+
+		if *unknownDetect {
+			var targets []webhook.Target
+			for _, u := range *unknownWebhookUrls {
+				targets = append(targets, webhook.Target{URL: u})
+			}
+			for _, u := range *unknownSlackWebhookUrls {
+				targets = append(targets, webhook.Target{URL: u, Slack: true})
+			}
+			notifier := webhook.New(targets, *unknownWebhookRetries)
+
+			stateFile, err := statePath("unknown.state-file", *unknownStateFile, "netgear_exporter_devices.json")
+			if err != nil {
+				slog.Error("failed to set up unknown client detection", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			inventory, err := collectors.NewInventory(*metricsNamespace, *unknownApprovedFile, deviceAliases, notifier, stateFile, time.Duration(*unknownForgetAfterDays)*24*time.Hour)
+			if err != nil {
+				slog.Error("failed to set up unknown client detection", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			if *unknownApprovedFile != "" {
+				reloadOnHangup("approved file", inventory.Reload)
+			}
+			prometheus.MustRegister(inventory)
+			running[filters.ClientCollector] = append(running[filters.ClientCollector], inventory)
+			observers = append(observers, inventory)
+		}
+
+		clientCollector := collectors.NewClientCollector(*metricsNamespace, netgearClient, *clientDetailed, clientsFilter, vendors, deviceAliases, observers...)
 		prometheus.MustRegister(clientCollector)
+		running[filters.ClientCollector] = append(running[filters.ClientCollector], clientCollector)
+
+		if maxAge > 0 {
+			/* Refreshes only feed discovery and the API, the events, trackers and inventory follow the scrapes */
+			refreshObservers := []collectors.ClientObserver{sdTargets, apiClients}
+			if *clientMergeRandomized {
+				refreshObservers = []collectors.ClientObserver{collectors.NewRandomizedMerger(time.Duration(*clientMergeForgetAfterDays)*24*time.Hour, refreshObservers...)}
+			}
+			refresher := collectors.NewRefresher(func() error { return clientCollector.Read(refreshObservers...) }, maxAge)
+			sdTargets.SetRefresh(refresher.Refresh, maxAge)
+			apiClients.SetRefresh(refresher.Refresh, maxAge)
+		}
+	}
+
+	if collectorsFilter.Enabled(filters.ClientBandwidthCollector) {
+		clientBandwidthCollector := collectors.NewClientBandwidthCollector(*metricsNamespace, netgearClient, *trafficUnitBytes, clientsFilter)
+		prometheus.MustRegister(clientBandwidthCollector)
+		running[filters.ClientBandwidthCollector] = append(running[filters.ClientBandwidthCollector], clientBandwidthCollector)
+	}
+
+	/* Collectors using SOAP actions netgear_client does not implement share their own session */
+	var soapClient *soap.Client
+	meterSettings := *trafficMeterSettings && collectorsFilter.Enabled(filters.TrafficCollector)
+	if collectorsFilter.Enabled(filters.PortMappingCollector) || collectorsFilter.Enabled(filters.QoSCollector) || meterSettings {
+		soapClient, err = soap.NewClient(*netgearUrl, *netgearInsecure, *netgearUsername, password, *netgearTimeout, *netgearClientDebug)
+		if err != nil {
+			slog.Error("error creating SOAP client", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	if collectorsFilter.Enabled(filters.PortMappingCollector) {
+		controlUrl := *upnpUrl
+		if controlUrl == "" {
+			controlUrl, err = defaultUPnPUrl(*netgearUrl)
+			if err != nil {
+				slog.Error("failed to derive the UPnP control URL", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+		portMappingCollector := collectors.NewPortMappingCollector(*metricsNamespace, soapClient, soap.NewUPnPClient(controlUrl, *netgearTimeout))
+		prometheus.MustRegister(portMappingCollector)
+		running[filters.PortMappingCollector] = append(running[filters.PortMappingCollector], portMappingCollector)
+	}
+
+	if collectorsFilter.Enabled(filters.QoSCollector) {
+		qosCollector := collectors.NewQoSCollector(*metricsNamespace, soapClient)
+		prometheus.MustRegister(qosCollector)
+		running[filters.QoSCollector] = append(running[filters.QoSCollector], qosCollector)
 	}
 
 	if collectorsFilter.Enabled(filters.SystemInfoCollector) {
-		systemInfoCollector := collectors.NewSystemInfoCollector(*metricsNamespace, netgearClient)
+		apiSystem = api.NewStats()
+		systemInfoCollector := collectors.NewSystemInfoCollector(*metricsNamespace, netgearClient, apiSystem)
 		prometheus.MustRegister(systemInfoCollector)
+		running[filters.SystemInfoCollector] = append(running[filters.SystemInfoCollector], systemInfoCollector)
+		if maxAge > 0 {
+			apiSystem.SetRefresh(collectors.NewRefresher(func() error { return systemInfoCollector.Read(apiSystem) }, maxAge).Refresh, maxAge)
+		}
+	}
+
+	var quota *collectors.Quota
+	if *quotaLimitBytes > 0 {
+		if !collectorsFilter.Enabled(filters.TrafficCollector) {
+			slog.Error("quota tracking needs the Traffic collector")
+			os.Exit(1)
+		}
+
+		stateFile, err := statePath("quota.state-file", *quotaStateFile, "netgear_exporter_quota.json")
+		if err != nil {
+			slog.Error("failed to set up quota tracking", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		quota, err = collectors.NewQuota(*metricsNamespace, *quotaLimitBytes, *quotaResetDay, stateFile)
+		if err != nil {
+			slog.Error("failed to set up quota tracking", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		prometheus.MustRegister(quota)
+		running[filters.TrafficCollector] = append(running[filters.TrafficCollector], quota)
 	}
 
 	if collectorsFilter.Enabled(filters.TrafficCollector) {
-		trafficCollector := collectors.NewTrafficCollector(*metricsNamespace, netgearClient)
+		apiTraffic = api.NewStats()
+		var meterClient *soap.Client
+		if meterSettings {
+			meterClient = soapClient
+		}
+		trafficCollector := collectors.NewTrafficCollector(*metricsNamespace, netgearClient, *trafficUnitBytes, *trafficFlatMetrics, quota, meterClient, apiTraffic)
 		prometheus.MustRegister(trafficCollector)
+		running[filters.TrafficCollector] = append(running[filters.TrafficCollector], trafficCollector)
+		if maxAge > 0 {
+			apiTraffic.SetRefresh(collectors.NewRefresher(func() error { return trafficCollector.Read(apiTraffic) }, maxAge).Refresh, maxAge)
+		}
+	}
+
+	/* Outputs that send the metrics on share one gather per interval instead of each reading the router */
+	poller := poll.New(prometheus.DefaultGatherer)
+	if publisher != nil {
+		publisher.Connect()
+		poller.Add(publisher)
 	}
 
-	handler := prometheusHandler()
+	if *pushUrl != "" {
+		grouping := make(map[string]string)
+		for name, value := range *pushGrouping {
+			grouping[name] = value
+		}
+		if _, found := grouping["instance"]; !found {
+			if grouping["instance"], err = routerHost(*netgearUrl); err != nil {
+				slog.Error("failed to derive the instance grouping label", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		pusher, err := pushgateway.New(pushgateway.Config{
+			URL:         *pushUrl,
+			Job:         *pushJob,
+			Grouping:    grouping,
+			Username:    *pushUsername,
+			Password:    os.Getenv("NETGEAR_EXPORTER_PUSH_PASSWORD"),
+			TLSCAFile:   *pushTlsCaFile,
+			TLSCertFile: *pushTlsCertFile,
+			TLSKeyFile:  *pushTlsKeyFile,
+			TLSInsecure: *pushTlsInsecure,
+			Interval:    time.Duration(*pushInterval) * time.Second,
+			Retries:     *pushRetries,
+		})
+		if err != nil {
+			slog.Error("failed to set up pushing to the Pushgateway", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		poller.Add(pusher)
+	}
+
+	if *remoteWriteUrl != "" {
+		labels := map[string]string{"job": "netgear_exporter"}
+		if labels["instance"], err = routerHost(*netgearUrl); err != nil {
+			slog.Error("failed to derive the instance label", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		for name, value := range *remoteWriteLabels {
+			labels[name] = value
+		}
+		queueDir, err := statePath("remote-write.queue-dir", *remoteWriteQueueDir, "netgear_exporter_wal")
+		if err != nil {
+			slog.Error("failed to set up remote write", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		writer, err := remotewrite.New(remotewrite.Config{
+			URL:             *remoteWriteUrl,
+			Username:        *remoteWriteUsername,
+			Password:        os.Getenv("NETGEAR_EXPORTER_REMOTE_WRITE_PASSWORD"),
+			TLSCAFile:       *remoteWriteTlsCaFile,
+			TLSCertFile:     *remoteWriteTlsCertFile,
+			TLSKeyFile:      *remoteWriteTlsKeyFile,
+			TLSInsecure:     *remoteWriteTlsInsecure,
+			Interval:        time.Duration(*remoteWriteInterval) * time.Second,
+			ExternalLabels:  labels,
+			QueueDir:        queueDir,
+			QueueMaxBatches: *remoteWriteQueueMaxBatches,
+		})
+		if err != nil {
+			slog.Error("failed to set up remote write", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		poller.Add(writer)
+	}
+
+	if *influxOutput != "" {
+		influxWriter, err := influx.New(influx.Config{
+			Output:    *influxOutput,
+			Org:       *influxOrg,
+			Bucket:    *influxBucket,
+			Token:     os.Getenv("NETGEAR_EXPORTER_INFLUX_TOKEN"),
+			Interval:  time.Duration(*influxInterval) * time.Second,
+			Namespace: *metricsNamespace,
+		})
+		if err != nil {
+			slog.Error("failed to set up InfluxDB output", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		poller.Add(influxWriter)
+	}
+
+	if *otlpEndpoint != "" {
+		attributes := map[string]string{
+			"service.name":       "netgear_exporter",
+			"service.version":    Version,
+			"netgear.router.url": *netgearUrl,
+		}
+		for name, value := range *otlpResourceAttributes {
+			attributes[name] = value
+		}
+
+		otlpExporter, err := otlp.New(otlp.Config{
+			Endpoint:           *otlpEndpoint,
+			Protocol:           *otlpProtocol,
+			Headers:            *otlpHeaders,
+			TLSCAFile:          *otlpTlsCaFile,
+			TLSCertFile:        *otlpTlsCertFile,
+			TLSKeyFile:         *otlpTlsKeyFile,
+			TLSInsecure:        *otlpTlsInsecure,
+			Interval:           time.Duration(*otlpInterval) * time.Second,
+			ResourceAttributes: attributes,
+			RouterModel: func() (string, error) {
+				info, err := netgearClient.GetDeviceInfo()
+				return info["ModelName"], err
+			},
+		})
+		if err != nil {
+			slog.Error("failed to set up OTLP export", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		poller.Add(otlpExporter)
+	}
+	go poller.Run()
+
+	handler := prometheusHandler(running)
 	http.Handle(*metricsPath, handler)
+	if eventStream != nil {
+		http.Handle(*eventsPath, authHandler(eventStream))
+	}
+	if sdTargets != nil {
+		http.Handle(*sdPath, authHandler(sdTargets))
+	}
+	apiPrefix := strings.TrimSuffix(*apiPath, "/")
+	if apiClients != nil {
+		http.Handle(apiPrefix+"/clients", authHandler(apiClients))
+	}
+	if apiSystem != nil {
+		http.Handle(apiPrefix+"/system", authHandler(api.SystemHandler(apiSystem)))
+	}
+	if apiTraffic != nil {
+		http.Handle(apiPrefix+"/traffic", authHandler(api.TrafficHandler(apiTraffic)))
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>Netgear Exporter</title></head>
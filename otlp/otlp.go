@@ -0,0 +1,389 @@
+// Package otlp exports the metrics to an OpenTelemetry collector over OTLP/HTTP or
+// OTLP/gRPC.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/tlsconfig"
+	dto "github.com/prometheus/client_model/go"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+)
+
+type Config struct {
+	// Endpoint is the URL of the collector: http(s)://host:4318 or the full path for
+	// HTTP, http(s)://host:4317 for gRPC, where http:// means without TLS
+	Endpoint string
+	Protocol string
+	// Headers are sent with every export, typically for authentication
+	Headers map[string]string
+
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSInsecure bool
+
+	Interval time.Duration
+	// ResourceAttributes describe the exporter and the router it reads
+	ResourceAttributes map[string]string
+	// RouterModel is asked for the netgear.router.model resource attribute until it answers
+	RouterModel func() (string, error)
+}
+
+const timeout = 10 * time.Second
+
+const scopeName = "github.com/DRuggeri/netgear_exporter"
+
+type Exporter struct {
+	config Config
+	start  time.Time
+
+	httpClient *http.Client
+	httpURL    string
+	grpcConn   *grpc.ClientConn
+	grpcClient colmetricspb.MetricsServiceClient
+
+	mutex sync.Mutex
+	model string
+}
+
+func New(config Config) (*Exporter, error) {
+	if config.Interval <= 0 {
+		return nil, errors.New("the OTLP export interval must be positive")
+	}
+
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("the OTLP endpoint %s must start with http:// or https://", config.Endpoint)
+	}
+
+	tlsConfig, err := tlsconfig.New(config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile, config.TLSInsecure)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Exporter{config: config, start: time.Now()}
+	switch config.Protocol {
+	case ProtocolHTTP:
+		/* Like the OpenTelemetry SDKs, a bare endpoint gets the default metrics path */
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/metrics"
+		}
+		e.httpURL = u.String()
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		e.httpClient = &http.Client{Timeout: timeout, Transport: transport}
+	case ProtocolGRPC:
+		creds := credentials.NewTLS(tlsConfig)
+		if u.Scheme == "http" {
+			creds = insecure.NewCredentials()
+		}
+		e.grpcConn, err = grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		e.grpcClient = colmetricspb.NewMetricsServiceClient(e.grpcConn)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %s, use grpc or http", config.Protocol)
+	}
+	return e, nil
+}
+
+func (e *Exporter) Interval() time.Duration {
+	return e.config.Interval
+}
+
+// Send exports the metrics gathered for this interval
+func (e *Exporter) Send(families []*dto.MetricFamily, now time.Time) {
+	if err := e.Export(families, now); err != nil {
+		slog.Error("failed to export metrics over OTLP", slog.String("endpoint", e.config.Endpoint), slog.String("error", err.Error()))
+	}
+}
+
+// Export sends the metrics to the collector
+func (e *Exporter) Export(families []*dto.MetricFamily, now time.Time) error {
+	request := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: attributes(e.resourceAttributes())},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: scopeName},
+				Metrics: metrics(families, e.start, now),
+			}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if e.grpcClient != nil {
+		return e.exportGRPC(ctx, request)
+	}
+	return e.exportHTTP(ctx, request)
+}
+
+func (e *Exporter) resourceAttributes() map[string]string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, found := e.config.ResourceAttributes["netgear.router.model"]; !found && e.model == "" && e.config.RouterModel != nil {
+		model, err := e.config.RouterModel()
+		if err != nil {
+			slog.Warn("failed to read the router model for the OTLP resource", slog.String("error", err.Error()))
+		}
+		e.model = model
+	}
+
+	result := make(map[string]string)
+	for name, value := range e.config.ResourceAttributes {
+		result[name] = value
+	}
+	if e.model != "" {
+		result["netgear.router.model"] = e.model
+	}
+	return result
+}
+
+func (e *Exporter) exportGRPC(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) error {
+	for name, value := range e.config.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, name, value)
+	}
+
+	response, err := e.grpcClient.Export(ctx, request)
+	if err != nil {
+		return err
+	}
+	if rejected := response.GetPartialSuccess().GetRejectedDataPoints(); rejected > 0 {
+		slog.Warn("OTLP collector rejected data points", slog.Int64("rejected", rejected), slog.String("message", response.GetPartialSuccess().GetErrorMessage()))
+	}
+	return nil
+}
+
+func (e *Exporter) exportHTTP(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.httpURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for name, value := range e.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var response colmetricspb.ExportMetricsServiceResponse
+	if err := proto.Unmarshal(data, &response); err == nil {
+		if rejected := response.GetPartialSuccess().GetRejectedDataPoints(); rejected > 0 {
+			slog.Warn("OTLP collector rejected data points", slog.Int64("rejected", rejected), slog.String("message", response.GetPartialSuccess().GetErrorMessage()))
+		}
+	}
+	return nil
+}
+
+// Close releases the gRPC connection
+func (e *Exporter) Close() error {
+	if e.grpcConn != nil {
+		return e.grpcConn.Close()
+	}
+	return nil
+}
+
+/* Sorted so the attributes are in the same order on every export */
+func attributes(values map[string]string) []*commonpb.KeyValue {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*commonpb.KeyValue, 0, len(names))
+	for _, name := range names {
+		result = append(result, &commonpb.KeyValue{
+			Key:   name,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: values[name]}},
+		})
+	}
+	return result
+}
+
+func labelAttributes(metric *dto.Metric) []*commonpb.KeyValue {
+	values := make(map[string]string)
+	for _, label := range metric.GetLabel() {
+		values[label.GetName()] = label.GetValue()
+	}
+	return attributes(values)
+}
+
+// metrics maps gauges to OTel gauges, counters to cumulative monotonic sums and
+// histograms and summaries to their OTel counterparts. Cumulative points start when the
+// counter was created, or when the exporter started for counters that do not say.
+func metrics(families []*dto.MetricFamily, start time.Time, now time.Time) []*metricspb.Metric {
+	var result []*metricspb.Metric
+	for _, family := range families {
+		m := &metricspb.Metric{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+			Unit:        unit(family.GetName()),
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricspb.Gauge{}
+			for _, metric := range family.GetMetric() {
+				value := metric.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = metric.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:   labelAttributes(metric),
+					TimeUnixNano: timestamp(metric, now),
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				})
+			}
+			m.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}
+			for _, metric := range family.GetMetric() {
+				counter := metric.GetCounter()
+				sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:        labelAttributes(metric),
+					StartTimeUnixNano: startTime(counter.GetCreatedTimestamp().AsTime(), counter.CreatedTimestamp != nil, start),
+					TimeUnixNano:      timestamp(metric, now),
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: counter.GetValue()},
+				})
+			}
+			m.Data = &metricspb.Metric_Sum{Sum: sum}
+
+		case dto.MetricType_HISTOGRAM:
+			histogram := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, metric := range family.GetMetric() {
+				histogram.DataPoints = append(histogram.DataPoints, histogramPoint(metric, start, now))
+			}
+			m.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+
+		case dto.MetricType_SUMMARY:
+			summary := &metricspb.Summary{}
+			for _, metric := range family.GetMetric() {
+				s := metric.GetSummary()
+				point := &metricspb.SummaryDataPoint{
+					Attributes:        labelAttributes(metric),
+					StartTimeUnixNano: startTime(s.GetCreatedTimestamp().AsTime(), s.CreatedTimestamp != nil, start),
+					TimeUnixNano:      timestamp(metric, now),
+					Count:             s.GetSampleCount(),
+					Sum:               s.GetSampleSum(),
+				}
+				for _, quantile := range s.GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+						Quantile: quantile.GetQuantile(),
+						Value:    quantile.GetValue(),
+					})
+				}
+				summary.DataPoints = append(summary.DataPoints, point)
+			}
+			m.Data = &metricspb.Metric_Summary{Summary: summary}
+
+		default:
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+/* Prometheus buckets count everything up to their bound, OTel buckets only what falls between two bounds */
+func histogramPoint(metric *dto.Metric, start time.Time, now time.Time) *metricspb.HistogramDataPoint {
+	h := metric.GetHistogram()
+	sum := h.GetSampleSum()
+	point := &metricspb.HistogramDataPoint{
+		Attributes:        labelAttributes(metric),
+		StartTimeUnixNano: startTime(h.GetCreatedTimestamp().AsTime(), h.CreatedTimestamp != nil, start),
+		TimeUnixNano:      timestamp(metric, now),
+		Count:             h.GetSampleCount(),
+		Sum:               &sum,
+	}
+
+	previous := uint64(0)
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+	return point
+}
+
+func timestamp(metric *dto.Metric, now time.Time) uint64 {
+	if metric.TimestampMs != nil {
+		return uint64(metric.GetTimestampMs()) * uint64(time.Millisecond)
+	}
+	return uint64(now.UnixNano())
+}
+
+func startTime(created time.Time, known bool, start time.Time) uint64 {
+	if known {
+		return uint64(created.UnixNano())
+	}
+	return uint64(start.UnixNano())
+}
+
+/* UCUM units of the Prometheus base unit suffixes */
+func unit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_bytes_per_second"):
+		return "By/s"
+	case strings.HasSuffix(name, "_bytes"), strings.HasSuffix(name, "_bytes_total"):
+		return "By"
+	case strings.HasSuffix(name, "_seconds"), strings.HasSuffix(name, "_seconds_total"):
+		return "s"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,261 @@
+package otlp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+/* An OTLP collector keeping the requests and headers it receives over either protocol */
+type receiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+
+	mutex         sync.Mutex
+	requests      []*colmetricspb.ExportMetricsServiceRequest
+	authorization string
+}
+
+func (r *receiver) Export(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		r.authorization = values[0]
+	}
+	r.requests = append(r.requests, request)
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v1/metrics" || req.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	data, _ := io.ReadAll(req.Body)
+	request := &colmetricspb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(data, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mutex.Lock()
+	r.authorization = req.Header.Get("Authorization")
+	r.requests = append(r.requests, request)
+	r.mutex.Unlock()
+
+	response, _ := proto.Marshal(&colmetricspb.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(response)
+}
+
+func (r *receiver) received() ([]*colmetricspb.ExportMetricsServiceRequest, string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests, r.authorization
+}
+
+func newTestRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	cpu := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_system_info_cpuutilization", Help: "cpu"})
+	cpu.Set(12)
+	traffic := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "netgear_traffic_bytes", Help: "traffic"}, []string{"period", "direction"})
+	traffic.WithLabelValues("today", "download").Set(1500000)
+	scrapes := prometheus.NewCounter(prometheus.CounterOpts{Name: "netgear_scrapes_total", Help: "scrapes"})
+	scrapes.Add(3)
+	speeds := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "netgear_speed", Help: "speed", Buckets: []float64{100, 1000}})
+	speeds.Observe(50)
+	speeds.Observe(866)
+	speeds.Observe(2400)
+	registry.MustRegister(cpu, traffic, scrapes, speeds)
+	return registry
+}
+
+func gather(t *testing.T) []*dto.MetricFamily {
+	families, err := newTestRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func testConfig(endpoint string, protocol string) Config {
+	return Config{
+		Endpoint:           endpoint,
+		Protocol:           protocol,
+		Headers:            map[string]string{"authorization": "Bearer secret"},
+		Interval:           time.Minute,
+		ResourceAttributes: map[string]string{"service.name": "netgear_exporter", "netgear.router.url": "https://192.168.1.1"},
+		RouterModel:        func() (string, error) { return "R7000", nil },
+	}
+}
+
+/* The metrics of the test registry, checked the same for both protocols */
+func checkRequest(t *testing.T, requests []*colmetricspb.ExportMetricsServiceRequest, authorization string) {
+	t.Helper()
+
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests", len(requests))
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("got authorization %q", authorization)
+	}
+
+	resource := requests[0].GetResourceMetrics()[0]
+	attributes := make(map[string]string)
+	for _, kv := range resource.GetResource().GetAttributes() {
+		attributes[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	want := map[string]string{"service.name": "netgear_exporter", "netgear.router.url": "https://192.168.1.1", "netgear.router.model": "R7000"}
+	if !reflect.DeepEqual(attributes, want) {
+		t.Errorf("got resource attributes %v", attributes)
+	}
+
+	scope := resource.GetScopeMetrics()[0]
+	if scope.GetScope().GetName() != scopeName {
+		t.Errorf("got scope %q", scope.GetScope().GetName())
+	}
+	byName := make(map[string]*metricspb.Metric)
+	for _, m := range scope.GetMetrics() {
+		byName[m.GetName()] = m
+	}
+
+	cpu := byName["netgear_system_info_cpuutilization"].GetGauge().GetDataPoints()
+	if len(cpu) != 1 || cpu[0].GetAsDouble() != 12 {
+		t.Errorf("got cpu %v", cpu)
+	}
+
+	traffic := byName["netgear_traffic_bytes"]
+	if traffic.GetUnit() != "By" {
+		t.Errorf("got traffic unit %q", traffic.GetUnit())
+	}
+	point := traffic.GetGauge().GetDataPoints()[0]
+	if point.GetAsDouble() != 1500000 || len(point.GetAttributes()) != 2 || point.GetAttributes()[0].GetKey() != "direction" {
+		t.Errorf("got traffic %v", point)
+	}
+
+	scrapes := byName["netgear_scrapes_total"].GetSum()
+	if !scrapes.GetIsMonotonic() || scrapes.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Errorf("got scrapes %v", scrapes)
+	}
+	if p := scrapes.GetDataPoints()[0]; p.GetAsDouble() != 3 || p.GetStartTimeUnixNano() == 0 || p.GetStartTimeUnixNano() > p.GetTimeUnixNano() {
+		t.Errorf("got scrapes point %v", p)
+	}
+
+	speed := byName["netgear_speed"].GetHistogram().GetDataPoints()[0]
+	if speed.GetCount() != 3 || speed.GetSum() != 3316 {
+		t.Errorf("got speed count %d sum %g", speed.GetCount(), speed.GetSum())
+	}
+	if !reflect.DeepEqual(speed.GetExplicitBounds(), []float64{100, 1000}) || !reflect.DeepEqual(speed.GetBucketCounts(), []uint64{1, 1, 1}) {
+		t.Errorf("got speed buckets %v %v", speed.GetExplicitBounds(), speed.GetBucketCounts())
+	}
+}
+
+func TestExportGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &receiver{}
+	server := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(server, r)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	e, err := New(testConfig("http://"+listener.Addr().String(), ProtocolGRPC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if err := e.Export(gather(t), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, authorization := r.received()
+	checkRequest(t, requests, authorization)
+}
+
+func TestExportHTTP(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	e, err := New(testConfig(server.URL, ProtocolHTTP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Export(gather(t), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, authorization := r.received()
+	checkRequest(t, requests, authorization)
+}
+
+func TestRouterModelRetried(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	calls := 0
+	config := testConfig(server.URL, ProtocolHTTP)
+	config.RouterModel = func() (string, error) {
+		calls++
+		if calls == 1 {
+			return "", errors.New("router unreachable")
+		}
+		return "R7000", nil
+	}
+	e, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := e.Export(gather(t), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("asked the router for its model %d times", calls)
+	}
+	requests, _ := r.received()
+	for i, request := range requests {
+		model := ""
+		for _, kv := range request.GetResourceMetrics()[0].GetResource().GetAttributes() {
+			if kv.GetKey() == "netgear.router.model" {
+				model = kv.GetValue().GetStringValue()
+			}
+		}
+		if (i == 0) != (model == "") {
+			t.Errorf("got model %q in export %d", model, i)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, config := range []Config{
+		{Endpoint: "http://localhost:4318", Protocol: ProtocolHTTP},
+		{Endpoint: "localhost:4317", Protocol: ProtocolGRPC, Interval: time.Minute},
+		{Endpoint: "http://localhost:4318", Protocol: "thrift", Interval: time.Minute},
+	} {
+		if _, err := New(config); err == nil {
+			t.Errorf("created an exporter for %+v", config)
+		}
+	}
+}
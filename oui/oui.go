@@ -0,0 +1,162 @@
+// Package oui resolves the vendor of a network device from the Organizationally
+// Unique Identifier at the start of its MAC address.
+package oui
+
+import (
+	"bufio"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The embedded database is whatever registries are committed in this directory, so
+// release builds are reproducible. As committed it only holds the MA-L assignments of
+// common vendors and empty MA-M and MA-S registries. Running go generate downloads the
+// full IEEE registries to commit instead; a full registry can also be loaded from a file.
+//
+//go:generate ../scripts/update_oui.sh
+//go:embed oui.csv mam.csv oui36.csv
+var embedded embed.FS
+
+/* The registries as published by the IEEE at https://standards-oui.ieee.org/ */
+var registries = []string{"oui.csv", "mam.csv", "oui36.csv"}
+
+/* Assignments are 24 (MA-L), 28 (MA-M) or 36 (MA-S) bits - the longest match wins */
+var prefixLengths = []int{9, 7, 6}
+
+type DB struct {
+	file string
+
+	mutex   sync.RWMutex
+	vendors map[string]string
+}
+
+// New creates the database from the embedded registries. When file is set, the
+// registry in it (IEEE oui.csv or oui.txt format) is loaded on top of them.
+func New(file string) (*DB, error) {
+	db := &DB{file: file}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reload reads the registry file again, for example after it was refreshed
+func (db *DB) Reload() error {
+	vendors := make(map[string]string)
+	for _, registry := range registries {
+		f, err := embedded.Open(registry)
+		if err != nil {
+			return err
+		}
+		err = parse(f, vendors)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("embedded OUI database %s: %s", registry, err)
+		}
+	}
+
+	if db.file != "" {
+		f, err := os.Open(db.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := parse(f, vendors); err != nil {
+			return fmt.Errorf("OUI database %s: %s", db.file, err)
+		}
+	}
+
+	db.mutex.Lock()
+	db.vendors = vendors
+	db.mutex.Unlock()
+	return nil
+}
+
+// Lookup returns the vendor of the MAC address or an empty string when it is unknown.
+// Locally administered (randomized) addresses have no vendor.
+func (db *DB) Lookup(mac string) string {
+	hex := strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.TrimSpace(mac)))
+	if len(hex) < 6 || strings.ContainsAny(hex[1:2], "2367ABEF") {
+		return ""
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	for _, length := range prefixLengths {
+		if len(hex) < length {
+			continue
+		}
+		if vendor, found := db.vendors[hex[:length]]; found {
+			return vendor
+		}
+	}
+	return ""
+}
+
+// Len is the number of assignments in the database
+func (db *DB) Len() int {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return len(db.vendors)
+}
+
+/* Detect the format from the first line and add the assignments to vendors */
+func parse(r io.Reader, vendors map[string]string) error {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(len("Registry,"))
+	if err == nil && string(first) == "Registry," {
+		return parseCSV(br, vendors)
+	}
+	return parseText(br, vendors)
+}
+
+/* Registry,Assignment,Organization Name,Organization Address */
+func parseCSV(r io.Reader, vendors map[string]string) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	if _, err := reader.Read(); err != nil {
+		return err
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 3 {
+			return errors.New(fmt.Sprintf("unexpected record `%s`", strings.Join(record, ",")))
+		}
+		addVendor(vendors, record[1], record[2])
+	}
+}
+
+/* 00-22-72   (hex)		American Micro-Fuel Device Corp. */
+func parseText(r io.Reader, vendors map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		prefix, vendor, found := strings.Cut(scanner.Text(), "(hex)")
+		if !found {
+			continue
+		}
+		addVendor(vendors, strings.ReplaceAll(strings.TrimSpace(prefix), "-", ""), vendor)
+	}
+	return scanner.Err()
+}
+
+func addVendor(vendors map[string]string, assignment string, vendor string) {
+	assignment = strings.ToUpper(strings.TrimSpace(assignment))
+	vendor = strings.TrimSpace(vendor)
+	if assignment != "" && vendor != "" {
+		vendors[assignment] = vendor
+	}
+}
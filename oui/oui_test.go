@@ -0,0 +1,67 @@
+package oui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupEmbedded(t *testing.T) {
+	db, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"b8:27:eb:12:34:56": "Raspberry Pi Foundation",
+		"00-09-5B-12-34-56": "NETGEAR",
+		"DA:A1:19:12:34:56": "",
+		"12:34:56:78:9A:BC": "",
+		"00:00":             "",
+	}
+	for mac, want := range tests {
+		/* The IEEE changes the case of some organization names between updates of the registry */
+		if got := db.Lookup(mac); !strings.EqualFold(got, want) {
+			t.Errorf("Lookup(%q) = %q, want %q", mac, got, want)
+		}
+	}
+}
+
+func TestReloadFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "oui.txt")
+	text := "OUI/MA-L                                                    Organization\n" +
+		"company_id                                                  Organization\n" +
+		"                                                            Address\n\n" +
+		"00-22-72   (hex)\t\tAmerican Micro-Fuel Device Corp.\n" +
+		"002272     (base 16)\t\tAmerican Micro-Fuel Device Corp.\n"
+	if err := os.WriteFile(file, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Lookup("00:22:72:00:00:01"); got != "American Micro-Fuel Device Corp." {
+		t.Errorf("got %q from the file", got)
+	}
+	if got := db.Lookup("b8:27:eb:12:34:56"); got != "Raspberry Pi Foundation" {
+		t.Errorf("got %q from the embedded database", got)
+	}
+
+	csv := "Registry,Assignment,Organization Name,Organization Address\n" +
+		"MA-M,0055DA1,\"Shinko Technos co.,ltd.\",Osaka JP 550-0012\n"
+	if err := os.WriteFile(file, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Lookup("00:55:DA:12:34:56"); got != "Shinko Technos co.,ltd." {
+		t.Errorf("got %q for an MA-M assignment", got)
+	}
+	if got := db.Lookup("00:22:72:00:00:01"); got != "" {
+		t.Errorf("got %q after the file changed", got)
+	}
+}
@@ -0,0 +1,108 @@
+// Package poll gathers the metrics on an interval for the outputs that send them on
+// instead of waiting for a scrape, so the router is read once per interval however many
+// outputs are enabled.
+package poll
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Output is handed the gathered metrics on every interval. Send logs its own errors.
+type Output interface {
+	Interval() time.Duration
+	Send(families []*dto.MetricFamily, now time.Time)
+}
+
+type batch struct {
+	families []*dto.MetricFamily
+	now      time.Time
+}
+
+type output struct {
+	Output
+	next    time.Time
+	batches chan batch
+}
+
+// Poller gathers the metrics for every output that is due in one go. Each output sends
+// in its own goroutine, so a slow endpoint or a retry does not hold up the others.
+type Poller struct {
+	gatherer prometheus.Gatherer
+	outputs  []*output
+}
+
+func New(gatherer prometheus.Gatherer) *Poller {
+	return &Poller{gatherer: gatherer}
+}
+
+// Add registers an output, which is first sent the metrics when the poller starts
+func (p *Poller) Add(o Output) {
+	p.outputs = append(p.outputs, &output{Output: o, batches: make(chan batch, 1)})
+}
+
+// Run polls until the program exits. It returns right away when there are no outputs.
+func (p *Poller) Run() {
+	if len(p.outputs) == 0 {
+		return
+	}
+	for _, o := range p.outputs {
+		go o.run()
+	}
+
+	for {
+		next := p.poll(time.Now())
+		time.Sleep(time.Until(next))
+	}
+}
+
+/* Gathers once for the outputs due at now and returns when the next one is due */
+func (p *Poller) poll(now time.Time) time.Time {
+	var due []*output
+	var next time.Time
+	for _, o := range p.outputs {
+		if !now.Before(o.next) {
+			due = append(due, o)
+			o.next = now.Add(o.Interval())
+		}
+		if next.IsZero() || o.next.Before(next) {
+			next = o.next
+		}
+	}
+	if len(due) == 0 {
+		return next
+	}
+
+	/* Gathering also runs the collectors that feed the client observers, so MQTT device trackers are current */
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		slog.Warn("some metrics could not be gathered for the outputs", slog.String("error", err.Error()))
+	}
+	for _, o := range due {
+		o.hand(batch{families: families, now: now})
+	}
+	return next
+}
+
+/* Queues the batch, replacing one the output did not get to yet - it only wants the latest metrics */
+func (o *output) hand(b batch) {
+	select {
+	case o.batches <- b:
+	default:
+		select {
+		case <-o.batches:
+			slog.Warn("an output is still sending, skipping its older metrics", slog.Duration("interval", o.Interval()))
+		default:
+		}
+		o.batches <- b
+	}
+}
+
+func (o *output) run() {
+	for b := range o.batches {
+		o.Send(b.families, b.now)
+	}
+}
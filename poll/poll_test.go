@@ -0,0 +1,82 @@
+package poll
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type fakeOutput struct {
+	interval time.Duration
+}
+
+func (o *fakeOutput) Interval() time.Duration {
+	return o.interval
+}
+
+func (o *fakeOutput) Send(families []*dto.MetricFamily, now time.Time) {}
+
+/* The times the output was handed metrics since the last call */
+func (o *output) received() []time.Time {
+	var times []time.Time
+	for {
+		select {
+		case b := <-o.batches:
+			times = append(times, b.now)
+		default:
+			return times
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	var gathers int
+	p := New(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		gathers++
+		return nil, nil
+	}))
+	p.Add(&fakeOutput{interval: time.Minute})
+	p.Add(&fakeOutput{interval: time.Minute})
+	p.Add(&fakeOutput{interval: 2 * time.Minute})
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := [][]int{{1, 1, 1}, {1, 1, 0}, {1, 1, 1}}
+	for i, counts := range want {
+		now := start.Add(time.Duration(i) * time.Minute)
+		if next := p.poll(now); !next.Equal(now.Add(time.Minute)) {
+			t.Errorf("poll %d: got the next poll at %v", i, next)
+		}
+		for j, o := range p.outputs {
+			if got := len(o.received()); got != counts[j] {
+				t.Errorf("poll %d: output %d got %d batches, want %d", i, j, got, counts[j])
+			}
+		}
+	}
+	if gathers != len(want) {
+		t.Errorf("gathered %d times for %d polls", gathers, len(want))
+	}
+
+	/* Nothing is due halfway through the interval */
+	if next := p.poll(start.Add(150 * time.Second)); !next.Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("got the next poll at %v", next)
+	}
+	if gathers != len(want) {
+		t.Errorf("gathered without an output being due")
+	}
+}
+
+func TestHandKeepsTheLatest(t *testing.T) {
+	o := &output{Output: &fakeOutput{interval: time.Minute}, batches: make(chan batch, 1)}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	/* The output is still busy sending, so only the newest batch waits for it */
+	for i := 0; i < 3; i++ {
+		o.hand(batch{now: start.Add(time.Duration(i) * time.Minute)})
+	}
+	got := o.received()
+	if len(got) != 1 || !got[0].Equal(start.Add(2*time.Minute)) {
+		t.Errorf("got batches of %v", got)
+	}
+}
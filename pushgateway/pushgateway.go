@@ -0,0 +1,120 @@
+// Package pushgateway pushes the metrics to a Prometheus Pushgateway, for exporters
+// Prometheus cannot reach.
+package pushgateway
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/tlsconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type Config struct {
+	URL string
+	Job string
+	// Grouping labels tell the metrics of different routers apart on the Pushgateway
+	Grouping map[string]string
+
+	Username string
+	Password string
+
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSInsecure bool
+
+	Interval time.Duration
+	// Retries is how many times a failed push is retried, waiting twice as long before every retry
+	Retries int
+}
+
+const timeout = 10 * time.Second
+
+type Pusher struct {
+	config  Config
+	pusher  *push.Pusher
+	backoff time.Duration
+
+	/* What the current push sends, so every retry sends the same metrics */
+	families []*dto.MetricFamily
+}
+
+func New(config Config) (*Pusher, error) {
+	if config.Interval <= 0 {
+		return nil, errors.New("the push interval must be positive")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.TLSCAFile != "" || config.TLSCertFile != "" || config.TLSInsecure {
+		tlsConfig, err := tlsconfig.New(config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile, config.TLSInsecure)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	p := &Pusher{
+		config:  config,
+		backoff: time.Second,
+	}
+	pusher := push.New(config.URL, config.Job).
+		Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return p.families, nil })).
+		Client(&http.Client{Timeout: timeout, Transport: transport})
+
+	/* Sorted so the grouping key reads the same in every log line */
+	names := make([]string, 0, len(config.Grouping))
+	for name := range config.Grouping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pusher = pusher.Grouping(name, config.Grouping[name])
+	}
+
+	if config.Username != "" {
+		pusher = pusher.BasicAuth(config.Username, config.Password)
+	}
+
+	p.pusher = pusher
+	return p, nil
+}
+
+func (p *Pusher) Interval() time.Duration {
+	return p.config.Interval
+}
+
+// Send pushes the metrics gathered for this interval
+func (p *Pusher) Send(families []*dto.MetricFamily, now time.Time) {
+	if err := p.Push(families); err != nil {
+		slog.Error("failed to push metrics to the Pushgateway", slog.String("url", p.config.URL), slog.String("error", err.Error()))
+	}
+}
+
+// Push replaces the metrics of the grouping on the Pushgateway, retrying with backoff.
+// Retries send the same metrics instead of reading the router again.
+func (p *Pusher) Push(families []*dto.MetricFamily) error {
+	p.families = families
+	defer func() { p.families = nil }()
+
+	wait := p.backoff
+	for attempt := 0; ; attempt++ {
+		err := p.pusher.Push()
+		if err == nil {
+			slog.Debug("pushed metrics to the Pushgateway", slog.String("url", p.config.URL))
+			return nil
+		}
+		if attempt >= p.config.Retries {
+			return err
+		}
+
+		slog.Warn("push to the Pushgateway failed, retrying", slog.String("url", p.config.URL), slog.Duration("wait", wait), slog.String("error", err.Error()))
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
@@ -0,0 +1,172 @@
+package pushgateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func gather(t *testing.T) []*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	cpu := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_system_info_cpuutilization", Help: "cpu"})
+	cpu.Set(12)
+	registry.MustRegister(cpu)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func TestPush(t *testing.T) {
+	var mutex sync.Mutex
+	var attempts int
+	var path, body, username, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodPut {
+			t.Errorf("got method %s", r.Method)
+		}
+		data, _ := io.ReadAll(r.Body)
+		path, body = r.URL.Path, string(data)
+		username, password, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{
+		URL:      server.URL,
+		Job:      "netgear_exporter",
+		Grouping: map[string]string{"router": "192.168.1.1", "site": "cabin"},
+		Username: "pusher",
+		Password: "secret",
+		Interval: time.Minute,
+		Retries:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.backoff = time.Millisecond
+
+	if err := p.Push(gather(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 3 {
+		t.Errorf("got %d attempts", attempts)
+	}
+	if path != "/metrics/job/netgear_exporter/router/192.168.1.1/site/cabin" {
+		t.Errorf("got path %s", path)
+	}
+	if username != "pusher" || password != "secret" {
+		t.Errorf("got basic auth %s:%s", username, password)
+	}
+	if !strings.Contains(body, "netgear_system_info_cpuutilization") {
+		t.Errorf("metric missing from %q", body)
+	}
+}
+
+func TestPushGivesUp(t *testing.T) {
+	var mutex sync.Mutex
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{URL: server.URL, Job: "netgear_exporter", Interval: time.Minute, Retries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.backoff = time.Millisecond
+
+	if err := p.Push(gather(t)); err == nil {
+		t.Error("push to a failing Pushgateway succeeded")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 4 {
+		t.Errorf("got %d attempts", attempts)
+	}
+}
+
+func TestPushRetriesWithTheSameMetrics(t *testing.T) {
+	var mutex sync.Mutex
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		attempts++
+		data, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(data), "netgear_system_info_cpuutilization") {
+			t.Errorf("attempt %d is missing the metric", attempts)
+		}
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, err := New(Config{URL: server.URL, Job: "netgear_exporter", Interval: time.Minute, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.backoff = time.Millisecond
+
+	if err := p.Push(gather(t)); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 3 {
+		t.Errorf("got %d attempts", attempts)
+	}
+}
+
+func TestPushTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := Config{URL: server.URL, Job: "netgear_exporter", Interval: time.Minute}
+	p, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Push(gather(t)); err == nil {
+		t.Error("push to a Pushgateway with an untrusted certificate succeeded")
+	}
+
+	config.TLSInsecure = true
+	p, err = New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Push(gather(t)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,113 @@
+package remotewrite
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Batches are named after a sequence number so their names sort in the order they were queued */
+const batchSuffix = ".batch"
+
+// queue keeps the compressed write requests that were not sent yet as one file per batch
+// in a directory, so they survive network outages and restarts
+type queue struct {
+	dir  string
+	max  int
+	next uint64
+}
+
+func newQueue(dir string, max int) (*queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	q := &queue{dir: dir, max: max}
+	names, err := q.batches()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		last, _ := strconv.ParseUint(strings.TrimSuffix(names[len(names)-1], batchSuffix), 10, 64)
+		q.next = last + 1
+		slog.Info("found queued remote write batches", slog.String("dir", dir), slog.Int("batches", len(names)))
+	}
+	return q, nil
+}
+
+/* The queued batch files, oldest first */
+func (q *queue) batches() ([]string, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), batchSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// push adds a batch, dropping the oldest ones when the queue is full
+func (q *queue) push(data []byte) error {
+	name := fmt.Sprintf("%020d%s", q.next, batchSuffix)
+
+	/* Write next to the real file and move it over so a crash never leaves half a batch behind */
+	tmp, err := os.CreateTemp(q.dir, name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(q.dir, name)); err != nil {
+		return err
+	}
+	q.next++
+
+	names, err := q.batches()
+	if err != nil {
+		return err
+	}
+	for len(names) > q.max {
+		slog.Warn("remote write queue is full, dropping the oldest batch", slog.String("batch", names[0]))
+		if err := q.remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// oldest returns the name and contents of the oldest batch, or an empty name when the
+// queue is empty
+func (q *queue) oldest() (string, []byte, error) {
+	names, err := q.batches()
+	if err != nil || len(names) == 0 {
+		return "", nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(q.dir, names[0]))
+	if err != nil {
+		return "", nil, err
+	}
+	return names[0], data, nil
+}
+
+func (q *queue) remove(name string) error {
+	return os.Remove(filepath.Join(q.dir, name))
+}
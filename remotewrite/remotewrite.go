@@ -0,0 +1,276 @@
+// Package remotewrite sends the metrics to a Prometheus remote write endpoint, so the
+// exporter can act as its own agent where there is no Prometheus to scrape it.
+package remotewrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/tlsconfig"
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type Config struct {
+	URL      string
+	Username string
+	Password string
+
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSInsecure bool
+
+	Interval time.Duration
+	// ExternalLabels are added to every series that does not have them already
+	ExternalLabels map[string]string
+
+	// QueueDir keeps the batches that could not be sent yet, up to QueueMaxBatches
+	QueueDir        string
+	QueueMaxBatches int
+}
+
+const timeout = 10 * time.Second
+
+type Writer struct {
+	config     Config
+	httpClient *http.Client
+	queue      *queue
+}
+
+func New(config Config) (*Writer, error) {
+	if config.Interval <= 0 {
+		return nil, errors.New("the remote write interval must be positive")
+	}
+	if config.QueueMaxBatches <= 0 {
+		return nil, errors.New("the remote write queue must hold at least one batch")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.TLSCAFile != "" || config.TLSCertFile != "" || config.TLSInsecure {
+		tlsConfig, err := tlsconfig.New(config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile, config.TLSInsecure)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	q, err := newQueue(config.QueueDir, config.QueueMaxBatches)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		config:     config,
+		httpClient: &http.Client{Timeout: timeout, Transport: transport},
+		queue:      q,
+	}, nil
+}
+
+func (w *Writer) Interval() time.Duration {
+	return w.config.Interval
+}
+
+// Send queues the metrics gathered for this interval and sends them along with whatever
+// is still queued. Batches that fail to send stay queued until the next interval.
+func (w *Writer) Send(families []*dto.MetricFamily, now time.Time) {
+	if err := w.Collect(families, now); err != nil {
+		slog.Error("failed to queue metrics for remote write", slog.String("error", err.Error()))
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("failed to remote write metrics, will retry", slog.String("url", w.config.URL), slog.String("error", err.Error()))
+	}
+}
+
+// Collect queues the metrics as one batch
+func (w *Writer) Collect(families []*dto.MetricFamily, now time.Time) error {
+	series := timeSeries(families, w.config.ExternalLabels, now)
+	if len(series) == 0 {
+		return nil
+	}
+	return w.queue.push(snappy.Encode(nil, encodeWriteRequest(series)))
+}
+
+// Flush sends the queued batches, oldest first, until the queue is empty or a batch
+// fails to send
+func (w *Writer) Flush() error {
+	for {
+		name, data, err := w.queue.oldest()
+		if err != nil || name == "" {
+			return err
+		}
+
+		retry, err := w.send(data)
+		if err != nil && retry {
+			return err
+		}
+		if err != nil {
+			/* The endpoint will never accept it - sending it again would block the queue */
+			slog.Error("remote write endpoint rejected a batch, dropping it", slog.String("batch", name), slog.String("error", err.Error()))
+		}
+		if err := w.queue.remove(name); err != nil {
+			return err
+		}
+	}
+}
+
+/* Send a compressed write request, reporting whether a failure is worth retrying */
+func (w *Writer) send(data []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "netgear_exporter")
+	if w.config.Username != "" {
+		req.SetBasicAuth(w.config.Username, w.config.Password)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5, err
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+/* Flatten the metric families into series the way Prometheus stores them after a scrape */
+func timeSeries(families []*dto.MetricFamily, externalLabels map[string]string, now time.Time) []series {
+	var result []series
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			timestamp := now.UnixMilli()
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			add := func(name string, value float64, extra ...label) {
+				labels := []label{{"__name__", name}}
+				for _, pair := range metric.GetLabel() {
+					labels = append(labels, label{pair.GetName(), pair.GetValue()})
+				}
+				labels = append(labels, extra...)
+				result = append(result, series{labels: withExternalLabels(labels, externalLabels), value: value, timestamp: timestamp})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add(name, quantile.GetValue(), label{"quantile", formatFloat(quantile.GetQuantile())})
+				}
+				add(name+"_sum", summary.GetSampleSum())
+				add(name+"_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				infinite := false
+				for _, bucket := range histogram.GetBucket() {
+					infinite = math.IsInf(bucket.GetUpperBound(), +1)
+					add(name+"_bucket", float64(bucket.GetCumulativeCount()), label{"le", formatFloat(bucket.GetUpperBound())})
+				}
+				/* The +Inf bucket is implicit unless it was given explicitly */
+				if !infinite {
+					add(name+"_bucket", float64(histogram.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add(name+"_sum", histogram.GetSampleSum())
+				add(name+"_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return result
+}
+
+/* Labels of a series must be sorted by name in a write request */
+func withExternalLabels(labels []label, externalLabels map[string]string) []label {
+	present := make(map[string]bool)
+	for _, l := range labels {
+		present[l.name] = true
+	}
+	for name, value := range externalLabels {
+		if !present[name] {
+			labels = append(labels, label{name, value})
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+	return labels
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes the series as a prometheus.WriteRequest protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(all []series) []byte {
+	var request []byte
+	for _, s := range all {
+		var ts []byte
+		for _, l := range s.labels {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, l.name)
+			encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, encoded)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return request
+}
@@ -0,0 +1,250 @@
+package remotewrite
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/* A remote write endpoint decoding what it receives into "name{labels} value @timestamp" lines */
+type receiver struct {
+	mutex    sync.Mutex
+	status   int
+	requests int
+	samples  []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requests++
+	if r.status != http.StatusOK {
+		w.WriteHeader(r.status)
+		return
+	}
+	if req.Header.Get("Content-Encoding") != "snappy" || req.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected headers", http.StatusBadRequest)
+		return
+	}
+
+	compressed, _ := io.ReadAll(req.Body)
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.samples = append(r.samples, decodeWriteRequest(data)...)
+}
+
+func (r *receiver) received() (int, []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests, r.samples
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mutex.Lock()
+	r.status = status
+	r.mutex.Unlock()
+}
+
+func fields(data []byte, each func(number protowire.Number, typ protowire.Type, value []byte, fixed uint64)) {
+	for len(data) > 0 {
+		number, typ, n := protowire.ConsumeTag(data)
+		data = data[n:]
+		switch typ {
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(data)
+			each(number, typ, value, 0)
+			data = data[n:]
+		case protowire.Fixed64Type:
+			value, n := protowire.ConsumeFixed64(data)
+			each(number, typ, nil, value)
+			data = data[n:]
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			each(number, typ, nil, value)
+			data = data[n:]
+		}
+	}
+}
+
+func decodeWriteRequest(data []byte) []string {
+	var samples []string
+	fields(data, func(_ protowire.Number, _ protowire.Type, ts []byte, _ uint64) {
+		var name string
+		var labels []string
+		var sample string
+		fields(ts, func(number protowire.Number, _ protowire.Type, value []byte, _ uint64) {
+			if number == 1 {
+				var l [2]string
+				fields(value, func(number protowire.Number, _ protowire.Type, value []byte, _ uint64) {
+					l[number-1] = string(value)
+				})
+				if l[0] == "__name__" {
+					name = l[1]
+				} else {
+					labels = append(labels, l[0]+"="+l[1])
+				}
+				return
+			}
+
+			var v float64
+			var timestamp int64
+			fields(value, func(number protowire.Number, _ protowire.Type, _ []byte, fixed uint64) {
+				if number == 1 {
+					v = math.Float64frombits(fixed)
+				} else {
+					timestamp = int64(fixed)
+				}
+			})
+			sample = " " + formatFloat(v) + " @" + strconv.FormatInt(timestamp, 10)
+		})
+		samples = append(samples, name+"{"+strings.Join(labels, ",")+"}"+sample)
+	})
+	return samples
+}
+
+func gather(t *testing.T) []*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	cpu := prometheus.NewGauge(prometheus.GaugeOpts{Name: "netgear_system_info_cpuutilization", Help: "cpu"})
+	cpu.Set(12)
+	scrapes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "netgear_scrapes_total", Help: "scrapes"}, []string{"instance"})
+	scrapes.WithLabelValues("override").Add(3)
+	speeds := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "netgear_speed", Help: "speed", Buckets: []float64{100, 1000}})
+	speeds.Observe(866)
+	registry.MustRegister(cpu, scrapes, speeds)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func TestWriter(t *testing.T) {
+	r := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	w, err := New(Config{
+		URL:             server.URL,
+		Interval:        time.Minute,
+		ExternalLabels:  map[string]string{"instance": "192.168.1.1", "job": "netgear_exporter"},
+		QueueDir:        t.TempDir(),
+		QueueMaxBatches: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.UnixMilli(1709294400000)
+	if err := w.Collect(gather(t), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, samples := r.received()
+	sort.Strings(samples)
+	want := []string{
+		"netgear_scrapes_total{instance=override,job=netgear_exporter} 3 @1709294400000",
+		"netgear_speed_bucket{instance=192.168.1.1,job=netgear_exporter,le=+Inf} 1 @1709294400000",
+		"netgear_speed_bucket{instance=192.168.1.1,job=netgear_exporter,le=1000} 1 @1709294400000",
+		"netgear_speed_bucket{instance=192.168.1.1,job=netgear_exporter,le=100} 0 @1709294400000",
+		"netgear_speed_count{instance=192.168.1.1,job=netgear_exporter} 1 @1709294400000",
+		"netgear_speed_sum{instance=192.168.1.1,job=netgear_exporter} 866 @1709294400000",
+		"netgear_system_info_cpuutilization{instance=192.168.1.1,job=netgear_exporter} 12 @1709294400000",
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(samples, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWriterQueuesWhileDown(t *testing.T) {
+	r := &receiver{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	config := Config{URL: server.URL, Interval: time.Minute, QueueDir: t.TempDir(), QueueMaxBatches: 2}
+	w, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		if err := w.Collect(gather(t), time.UnixMilli(i*1000)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err == nil {
+			t.Fatal("flush to a failing endpoint succeeded")
+		}
+	}
+	if entries, _ := os.ReadDir(config.QueueDir); len(entries) != 2 {
+		t.Errorf("got %d queued batches, want 2", len(entries))
+	}
+
+	/* The batches on disk are sent by the next writer, the oldest one was dropped */
+	r.setStatus(http.StatusOK)
+	w, err = New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, samples := r.received()
+	if requests != 5 {
+		t.Errorf("got %d requests", requests)
+	}
+	var timestamps []string
+	for _, sample := range samples {
+		if strings.HasPrefix(sample, "netgear_system_info_cpuutilization") {
+			timestamps = append(timestamps, sample[strings.LastIndex(sample, "@")+1:])
+		}
+	}
+	if !reflect.DeepEqual(timestamps, []string{"2000", "3000"}) {
+		t.Errorf("got timestamps %v", timestamps)
+	}
+	if entries, _ := os.ReadDir(config.QueueDir); len(entries) != 0 {
+		t.Errorf("got %d queued batches after sending", len(entries))
+	}
+}
+
+func TestWriterDropsRejectedBatches(t *testing.T) {
+	r := &receiver{status: http.StatusBadRequest}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	config := Config{URL: server.URL, Interval: time.Minute, QueueDir: t.TempDir(), QueueMaxBatches: 10}
+	w, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Collect(gather(t), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(config.QueueDir); len(entries) != 0 {
+		t.Errorf("got %d queued batches after a rejection", len(entries))
+	}
+}
@@ -0,0 +1,20 @@
+#!/bin/bash -e
+
+echo "Downloading the IEEE MA-L, MA-M and MA-S registries..."
+#Get into the right directory
+cd $(dirname $0)/../oui
+
+#The IEEE rejects requests without a browser-like user agent
+AGENT="Mozilla/5.0 (compatible; netgear_exporter)"
+
+for REGISTRY in oui/oui.csv oui28/mam.csv oui36/oui36.csv;do
+  FILE="${REGISTRY##*/}"
+  curl -fsSL -A "$AGENT" -o "$FILE.tmp" "https://standards-oui.ieee.org/$REGISTRY"
+  if ! head -1 "$FILE.tmp" | grep -q '^Registry,';then
+    echo "===Unexpected contents in $REGISTRY"
+    rm "$FILE.tmp"
+    exit 1
+  fi
+  mv "$FILE.tmp" "$FILE"
+  echo "$FILE: $(($(wc -l < "$FILE")-1)) assignments"
+done
@@ -0,0 +1,152 @@
+// Package sd serves the attached devices as Prometheus HTTP service discovery targets.
+package sd
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+	"github.com/DRuggeri/netgear_exporter/filters"
+)
+
+/* Labels starting with __meta_ are available for relabeling and dropped afterwards */
+const (
+	macLabel            = "__meta_netgear_client_mac"
+	nameLabel           = "__meta_netgear_client_name"
+	connectionTypeLabel = "__meta_netgear_client_connection_type"
+	aliasLabel          = "__meta_netgear_client_alias"
+	groupLabel          = "__meta_netgear_client_group"
+)
+
+// TargetGroup is an entry of the http_sd_config response, see
+// https://prometheus.io/docs/prometheus/latest/http_sd/
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// Targets remembers the attached clients of the last scrape and serves one target group
+// per client with an IP address
+type Targets struct {
+	aliases *aliases.Aliases
+	refresh func()
+	maxAge  time.Duration
+
+	mutex   sync.RWMutex
+	time    time.Time
+	clients []map[string]string
+}
+
+func NewTargets(aliases *aliases.Aliases) *Targets {
+	return &Targets{aliases: aliases}
+}
+
+// SetRefresh has requests call refresh to read the attached clients again when the last
+// ones are older than maxAge, so discovery does not depend on scrapes of the metrics
+func (t *Targets) SetRefresh(refresh func(), maxAge time.Duration) {
+	t.refresh = refresh
+	t.maxAge = maxAge
+}
+
+// Observe remembers the attached clients (GetAttachDevice fields)
+func (t *Targets) Observe(clients []map[string]string, now time.Time) {
+	t.mutex.Lock()
+	t.clients = clients
+	t.time = now
+	t.mutex.Unlock()
+}
+
+/* Reads the attached clients again when there are none yet or they are too old */
+func (t *Targets) refreshStale() {
+	if t.refresh == nil {
+		return
+	}
+	t.mutex.RLock()
+	stale := time.Since(t.time) > t.maxAge
+	t.mutex.RUnlock()
+	if stale {
+		t.refresh()
+	}
+}
+
+// ServeHTTP returns the target groups, limited to the clients whose name matches the
+// name regular expression and the clients in the group when asked for. The port is
+// added to the IP address of every target when given.
+func (t *Targets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var name *regexp.Regexp
+	if query.Get("name") != "" {
+		var err error
+		name, err = regexp.Compile(query.Get("name"))
+		if err != nil {
+			http.Error(w, "Invalid name regular expression: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	port := query.Get("port")
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			http.Error(w, "Invalid port "+port, http.StatusBadRequest)
+			return
+		}
+	}
+
+	t.refreshStale()
+	groups := t.targetGroups(name, query.Get("group"), port)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		slog.Error("failed to write service discovery targets", slog.String("error", err.Error()))
+	}
+}
+
+func (t *Targets) targetGroups(name *regexp.Regexp, group string, port string) []TargetGroup {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	/* An empty list, not null, tells Prometheus there are no targets */
+	groups := []TargetGroup{}
+	for _, client := range t.clients {
+		mac := filters.NormalizeMAC(client["MACAddress"])
+		ip := client["IPAddress"]
+		if mac == "" || ip == "" {
+			continue
+		}
+		if name != nil && !name.MatchString(client["Name"]) {
+			continue
+		}
+		alias, _ := t.aliases.Lookup(mac)
+		if group != "" && alias.Group != group {
+			continue
+		}
+
+		target := ip
+		if port != "" {
+			target = net.JoinHostPort(ip, port)
+		}
+		groups = append(groups, TargetGroup{
+			Targets: []string{target},
+			Labels: map[string]string{
+				macLabel:            mac,
+				nameLabel:           client["Name"],
+				connectionTypeLabel: client["ConnectionType"],
+				aliasLabel:          alias.Alias,
+				groupLabel:          alias.Group,
+			},
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Labels[macLabel] < groups[j].Labels[macLabel]
+	})
+	return groups
+}
@@ -0,0 +1,131 @@
+package sd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DRuggeri/netgear_exporter/aliases"
+)
+
+func newTestTargets(t *testing.T) *Targets {
+	aliasFile := filepath.Join(t.TempDir(), "aliases.csv")
+	if err := os.WriteFile(aliasFile, []byte("AA:BB:CC:00:00:02,Media box,,media\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deviceAliases, err := aliases.New(aliasFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := NewTargets(deviceAliases)
+	targets.Observe([]map[string]string{
+		{"MACAddress": "aa:bb:cc:00:00:02", "Name": "nas", "IPAddress": "192.168.1.20", "ConnectionType": "wired"},
+		{"MACAddress": "AA:BB:CC:00:00:01", "Name": "laptop", "IPAddress": "192.168.1.10", "ConnectionType": "5G"},
+		{"MACAddress": "AA:BB:CC:00:00:03", "Name": "printer", "IPAddress": "", "ConnectionType": "2.4G"},
+	}, time.Now())
+	return targets
+}
+
+func get(t *testing.T, targets *Targets, url string) ([]TargetGroup, int) {
+	w := httptest.NewRecorder()
+	targets.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != http.StatusOK {
+		return nil, w.Code
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %s", ct)
+	}
+
+	var groups []TargetGroup
+	if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+		t.Fatal(err)
+	}
+	return groups, w.Code
+}
+
+func TestServeHTTP(t *testing.T) {
+	targets := newTestTargets(t)
+
+	groups, _ := get(t, targets, "/sd")
+	want := []TargetGroup{
+		{Targets: []string{"192.168.1.10"}, Labels: map[string]string{
+			macLabel: "AA:BB:CC:00:00:01", nameLabel: "laptop", connectionTypeLabel: "5G", aliasLabel: "", groupLabel: "",
+		}},
+		{Targets: []string{"192.168.1.20"}, Labels: map[string]string{
+			macLabel: "AA:BB:CC:00:00:02", nameLabel: "nas", connectionTypeLabel: "wired", aliasLabel: "Media box", groupLabel: "media",
+		}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("got %+v, want %+v", groups, want)
+	}
+}
+
+func TestServeHTTPFiltered(t *testing.T) {
+	targets := newTestTargets(t)
+
+	tests := map[string][]string{
+		"/sd?name=^lap":           {"192.168.1.10"},
+		"/sd?group=media":         {"192.168.1.20"},
+		"/sd?group=kids":          {},
+		"/sd?port=9100":           {"192.168.1.10:9100", "192.168.1.20:9100"},
+		"/sd?name=nas&port=9115":  {"192.168.1.20:9115"},
+		"/sd?name=nas&group=kids": {},
+	}
+	for url, want := range tests {
+		groups, code := get(t, targets, url)
+		if code != http.StatusOK {
+			t.Errorf("%s: got status %d", url, code)
+			continue
+		}
+		got := []string{}
+		for _, group := range groups {
+			got = append(got, group.Targets...)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", url, got, want)
+		}
+	}
+
+	for _, url := range []string{"/sd?name=(", "/sd?port=http", "/sd?port=70000"} {
+		if _, code := get(t, targets, url); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", url, code)
+		}
+	}
+}
+
+func TestServeHTTPEmpty(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewTargets(nil).ServeHTTP(w, httptest.NewRequest("GET", "/sd", nil))
+	if body := w.Body.String(); body != "[]\n" {
+		t.Errorf("got %q", body)
+	}
+}
+
+func TestServeHTTPRefreshes(t *testing.T) {
+	deviceAliases, _ := aliases.New("")
+	targets := NewTargets(deviceAliases)
+	var refreshes int
+	targets.SetRefresh(func() {
+		refreshes++
+		targets.Observe([]map[string]string{{"MACAddress": "AA:BB:CC:00:00:01", "Name": "laptop", "IPAddress": "192.168.1.10"}}, time.Now())
+	}, time.Minute)
+
+	/* Nothing was scraped yet, so the request reads the clients itself */
+	if groups, _ := get(t, targets, "/sd"); len(groups) != 1 || refreshes != 1 {
+		t.Errorf("got %+v after %d refreshes", groups, refreshes)
+	}
+	if get(t, targets, "/sd"); refreshes != 1 {
+		t.Errorf("refreshed %d times while the clients were current", refreshes)
+	}
+
+	targets.Observe(nil, time.Now().Add(-2*time.Minute))
+	if groups, _ := get(t, targets, "/sd"); len(groups) != 1 || refreshes != 2 {
+		t.Errorf("got %+v after %d refreshes of old clients", groups, refreshes)
+	}
+}
@@ -0,0 +1,243 @@
+package soap
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* The SOAP actions implemented by netgear_client cover the basics. This package
+   speaks the same protocol for the handful of additional actions the exporter
+   needs, using its own session with the router.
+*/
+
+const (
+	soapPath  = "/soap/server_sa/"
+	sessionID = "A7D88AE69687E58D9A00"
+	urnPrefix = "urn:NETGEAR-ROUTER:service:"
+)
+
+const requestTemplate = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<SOAP-ENV:Envelope
+  xmlns:SOAPSDK1="http://www.w3.org/2001/XMLSchema"
+  xmlns:SOAPSDK2="http://www.w3.org/2001/XMLSchema-instance"
+  xmlns:SOAPSDK3="http://schemas.xmlsoap.org/soap/encoding/"
+  xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">
+  <SOAP-ENV:Header>
+    <SessionID>%s</SessionID>
+  </SOAP-ENV:Header>
+  <SOAP-ENV:Body>
+    <M1:%s xmlns:M1="%s">
+%s    </M1:%s>
+  </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+// ErrNotLoggedIn is returned when the router keeps rejecting the session
+var ErrNotLoggedIn = errors.New("the SOAP client is not logged in")
+
+type envelope struct {
+	Body struct {
+		ResponseCode    string `xml:"ResponseCode"`
+		ResponseContent []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// Node is an XML element of a SOAP response
+type Node struct {
+	XMLName xml.Name
+	Content string `xml:",innerxml"`
+	Nodes   []Node `xml:",any"`
+}
+
+// Param is a single argument of a SOAP action. Order matters to some firmware
+type Param struct {
+	Name  string
+	Value string
+}
+
+// ResponseError is returned when the router answers with a non-zero response code
+type ResponseError struct {
+	Action string
+	Code   string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("router answered %s with response code %s", e.Action, e.Code)
+}
+
+// Unsupported reports whether the response code means the firmware does not implement the action
+func (e *ResponseError) Unsupported() bool {
+	return e.Code == "404" || e.Code == "501"
+}
+
+type Client struct {
+	httpClient *http.Client
+	routerURL  string
+	username   string
+	password   string
+	debug      bool
+
+	mutex  sync.Mutex
+	cookie string
+}
+
+func NewClient(routerURL string, insecure bool, username string, password string, timeout int, debug bool) (*Client, error) {
+	if routerURL == "" {
+		routerURL = "https://routerlogin.net"
+	}
+	if username == "" {
+		username = "admin"
+	}
+	if password == "" {
+		return nil, errors.New("admin password is required")
+	}
+
+	routerURL = strings.TrimSuffix(routerURL, "/")
+	if !strings.Contains(routerURL, "://") {
+		routerURL = "https://" + routerURL
+	}
+
+	if _, err := url.Parse(routerURL); err != nil {
+		return nil, fmt.Errorf("error parsing provided URL (%s): %v", routerURL, err)
+	}
+
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: time.Second * time.Duration(timeout),
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+		routerURL: routerURL,
+		username:  username,
+		password:  password,
+		debug:     debug,
+		cookie:    "UNSET",
+	}, nil
+}
+
+// Call executes an action of a service and returns the fields of the response.
+// The "New" prefix Netgear puts on every field name is removed.
+func (c *Client) Call(service string, action string, params ...Param) (map[string]string, error) {
+	nodes, err := c.CallRaw(service, action, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	for _, n := range nodes {
+		fields[strings.TrimPrefix(n.XMLName.Local, "New")] = strings.TrimSpace(html.UnescapeString(n.Content))
+	}
+	return fields, nil
+}
+
+// CallRaw executes an action of a service and returns the child elements of the response
+func (c *Client) CallRaw(service string, action string, params ...Param) ([]Node, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		content, err := c.send(service, action, params)
+		if err == nil {
+			/* The body holds the <m:ActionResponse> element next to <ResponseCode> */
+			var body Node
+			if err = xml.Unmarshal([]byte("<body>"+string(content)+"</body>"), &body); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal response from inside SOAP body: %v", err)
+			}
+			for _, n := range body.Nodes {
+				if n.XMLName.Local != "ResponseCode" {
+					return n.Nodes, nil
+				}
+			}
+			return []Node{}, nil
+		}
+
+		var respErr *ResponseError
+		if attempt == 0 && errors.As(err, &respErr) && respErr.Code == "401" {
+			if c.debug {
+				slog.Debug("SOAP session is not logged in, logging in", slog.String("action", action))
+			}
+			if err := c.logIn(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, err
+	}
+
+	return nil, ErrNotLoggedIn
+}
+
+func (c *Client) logIn() error {
+	_, err := c.send("DeviceConfig", "SOAPLogin", []Param{
+		{Name: "Username", Value: c.username},
+		{Name: "Password", Value: c.password},
+	})
+	return err
+}
+
+func (c *Client) send(service string, action string, params []Param) ([]byte, error) {
+	urn := urnPrefix + service + ":1"
+
+	var args strings.Builder
+	for _, p := range params {
+		fmt.Fprintf(&args, "      <%s>%s</%s>\n", p.Name, html.EscapeString(p.Value), p.Name)
+	}
+	data := fmt.Sprintf(requestTemplate, sessionID, action, urn, args.String(), action)
+
+	req, err := http.NewRequest("POST", c.routerURL+soapPath, bytes.NewBufferString(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "text/xml;charset=utf-8")
+	req.Header.Set("SOAPAction", urn+"#"+action)
+	req.Header.Set("Host", "routerlogin.net")
+	req.Header.Set("Cookie", c.cookie)
+	req.Header.Set("User-Agent", "curl/7.59.0")
+
+	if c.debug {
+		slog.Debug("sending SOAP request", slog.String("url", req.URL.String()), slog.String("action", action))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if cookie := resp.Header.Get("Set-Cookie"); cookie != "" {
+		c.cookie = cookie
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.debug {
+		slog.Debug("received SOAP response", slog.Int("status", resp.StatusCode), slog.String("body", string(body)))
+	}
+
+	var response envelope
+	if err := xml.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	code := strings.TrimSpace(response.Body.ResponseCode)
+	if code != "" && code != "0" && code != "000" {
+		return nil, &ResponseError{Action: action, Code: code}
+	}
+
+	return response.Body.ResponseContent, nil
+}
@@ -0,0 +1,90 @@
+package soap
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<soap-env:Envelope xmlns:soap-env="http://schemas.xmlsoap.org/soap/envelope/">
+<soap-env:Body>
+<m:%sResponse xmlns:m="urn:NETGEAR-ROUTER:service:AdvancedQOS:1">
+<NewUplinkBandwidth>20.00</NewUplinkBandwidth>
+<NewDownlinkBandwidth>100.00</NewDownlinkBandwidth>
+</m:%sResponse>
+<ResponseCode>%s</ResponseCode>
+</soap-env:Body>
+</soap-env:Envelope>`
+
+func TestCallLogsInOnce(t *testing.T) {
+	loggedIn := false
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+		action = action[strings.Index(action, "#")+1:]
+
+		code := "000"
+		switch {
+		case action == "SOAPLogin":
+			if !strings.Contains(string(body), "<Password>secret</Password>") {
+				t.Errorf("login request does not carry the password: %s", body)
+			}
+			logins++
+			loggedIn = true
+			w.Header().Set("Set-Cookie", "sess=1")
+		case !loggedIn || r.Header.Get("Cookie") != "sess=1":
+			code = "401"
+		}
+		w.Write([]byte(fmtResponse(action, code)))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, false, "admin", "secret", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		fields, err := client.GetBandwidthControlOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields["UplinkBandwidth"] != "20.00" || fields["DownlinkBandwidth"] != "100.00" {
+			t.Errorf("unexpected fields: %v", fields)
+		}
+	}
+
+	if logins != 1 {
+		t.Errorf("want 1 login, have %d", logins)
+	}
+}
+
+func TestCallResponseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmtResponse("GetOOKLASpeedTestResult", "501")))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, false, "admin", "secret", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GetOOKLASpeedTestResult()
+	respErr, ok := err.(*ResponseError)
+	if !ok {
+		t.Fatalf("want a ResponseError, have %v", err)
+	}
+	if !respErr.Unsupported() {
+		t.Errorf("want response code %s to mean unsupported", respErr.Code)
+	}
+}
+
+func fmtResponse(action string, code string) string {
+	return fmt.Sprintf(testResponse, action, action, code)
+}
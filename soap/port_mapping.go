@@ -0,0 +1,199 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// PortMapping is a port forwarding rule or a UPnP port mapping. Enabled is only reported
+// for UPnP port mappings, the router does not say whether a static rule is enabled.
+type PortMapping struct {
+	Description    string
+	Protocol       string
+	ExternalPort   string
+	InternalClient string
+	InternalPort   string
+	Enabled        bool
+}
+
+// ErrUPnPUnsupported is returned when the router does not serve UPnP, because it is turned
+// off or the firmware lacks it
+var ErrUPnPUnsupported = errors.New("router does not serve UPnP")
+
+// GetPortMappingInfo implements the WANIPConnection/GetPortMappingInfo SOAP message which
+// lists the static port forwarding rules. The rules are returned as "@" separated records of
+// ";" separated fields (description;protocol;external port;internal port;internal IP), the
+// same way GetAttachDevice returns devices.
+func (c *Client) GetPortMappingInfo() ([]PortMapping, error) {
+	fields, err := c.Call("WANIPConnection", "GetPortMappingInfo")
+	if err != nil {
+		return nil, err
+	}
+	return parsePortMappingInfo(fields["PortMappingInfo"])
+}
+
+func parsePortMappingInfo(info string) ([]PortMapping, error) {
+	mappings := make([]PortMapping, 0)
+	for _, record := range strings.Split(info, "@") {
+		/* The list ends with a separator */
+		if record == "" {
+			continue
+		}
+
+		values := strings.Split(record, ";")
+		if len(values) != 5 {
+			return nil, fmt.Errorf("port forwarding rule %q has %d fields instead of 5", record, len(values))
+		}
+		for _, port := range values[2:4] {
+			if !validPortRange(port) {
+				return nil, fmt.Errorf("port forwarding rule %q has an invalid port %q", record, port)
+			}
+		}
+		mappings = append(mappings, PortMapping{
+			Description:    values[0],
+			Protocol:       strings.ToUpper(values[1]),
+			ExternalPort:   values[2],
+			InternalPort:   values[3],
+			InternalClient: values[4],
+		})
+	}
+	return mappings, nil
+}
+
+/* A port or a range of ports like "6000-6010", which forwarding rules may cover */
+func validPortRange(ports string) bool {
+	first, last, isRange := strings.Cut(ports, "-")
+	if !validPort(first) {
+		return false
+	}
+	return !isRange || validPort(last)
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+/* UPnP port mappings are not part of the Netgear SOAP API. They are read from the
+   standard UPnP IGD WANIPConnection service, which does not need a login.
+*/
+
+const upnpService = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+const upnpRequestTemplate = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetGenericPortMappingEntry xmlns:u="` + upnpService + `">
+      <NewPortMappingIndex>%d</NewPortMappingIndex>
+    </u:GetGenericPortMappingEntry>
+  </s:Body>
+</s:Envelope>`
+
+// The IGD specification has no count action - entries are read by index until the router
+// answers with SpecifiedArrayIndexInvalid. Stop at a sane number in case it never does.
+const maxUPnPMappings = 1024
+
+type upnpEnvelope struct {
+	Body struct {
+		Response struct {
+			ExternalPort   string `xml:"NewExternalPort"`
+			Protocol       string `xml:"NewProtocol"`
+			InternalPort   string `xml:"NewInternalPort"`
+			InternalClient string `xml:"NewInternalClient"`
+			Enabled        string `xml:"NewEnabled"`
+			Description    string `xml:"NewPortMappingDescription"`
+		} `xml:"GetGenericPortMappingEntryResponse"`
+		Fault struct {
+			ErrorCode string `xml:"detail>UPnPError>errorCode"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+type UPnPClient struct {
+	httpClient *http.Client
+	controlURL string
+}
+
+func NewUPnPClient(controlURL string, timeout int) *UPnPClient {
+	return &UPnPClient{
+		httpClient: &http.Client{Timeout: time.Second * time.Duration(timeout)},
+		controlURL: controlURL,
+	}
+}
+
+// GetGenericPortMappingEntries returns all dynamic port mappings of the UPnP IGD service
+func (c *UPnPClient) GetGenericPortMappingEntries() ([]PortMapping, error) {
+	mappings := make([]PortMapping, 0)
+	for i := 0; i < maxUPnPMappings; i++ {
+		mapping, err := c.getGenericPortMappingEntry(i)
+		if err != nil {
+			return nil, err
+		}
+		if mapping == nil {
+			break
+		}
+		mappings = append(mappings, *mapping)
+	}
+	return mappings, nil
+}
+
+func (c *UPnPClient) getGenericPortMappingEntry(index int) (*PortMapping, error) {
+	req, err := http.NewRequest("POST", c.controlURL, bytes.NewBufferString(fmt.Sprintf(upnpRequestTemplate, index)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+upnpService+`#GetGenericPortMappingEntry"`)
+
+	resp, err := c.httpClient.Do(req)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		/* Nothing listens when UPnP is turned off on the router */
+		return nil, fmt.Errorf("%w: %w", ErrUPnPUnsupported, err)
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response upnpEnvelope
+	xmlErr := xml.Unmarshal(body, &response)
+	code := response.Body.Fault.ErrorCode
+
+	switch {
+	case code == "713" || code == "714":
+		/* SpecifiedArrayIndexInvalid or NoSuchEntryInArray - the end of the list */
+		return nil, nil
+	case code == "401" || code == "501" || (code == "" && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusNotImplemented)):
+		/* Invalid Action or Action Failed, or the HTTP status of the same on firmware without the service */
+		return nil, fmt.Errorf("%w: GetGenericPortMappingEntry failed with HTTP %d and error code %q", ErrUPnPUnsupported, resp.StatusCode, code)
+	case xmlErr != nil:
+		return nil, fmt.Errorf("failed to unmarshal UPnP response (HTTP %d): %v", resp.StatusCode, xmlErr)
+	case code != "":
+		return nil, fmt.Errorf("UPnP GetGenericPortMappingEntry failed with error code %s", code)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("UPnP GetGenericPortMappingEntry failed with HTTP %d", resp.StatusCode)
+	}
+
+	r := response.Body.Response
+	enabled, _ := strconv.ParseBool(r.Enabled)
+	return &PortMapping{
+		Description:    r.Description,
+		Protocol:       strings.ToUpper(r.Protocol),
+		ExternalPort:   r.ExternalPort,
+		InternalClient: r.InternalClient,
+		InternalPort:   r.InternalPort,
+		Enabled:        enabled,
+	}, nil
+}
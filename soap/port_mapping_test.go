@@ -0,0 +1,107 @@
+package soap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetGenericPortMappingEntries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "<NewPortMappingIndex>0</NewPortMappingIndex>") {
+			fmt.Fprint(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetGenericPortMappingEntryResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewRemoteHost></NewRemoteHost><NewExternalPort>51413</NewExternalPort><NewProtocol>tcp</NewProtocol>
+<NewInternalPort>51413</NewInternalPort><NewInternalClient>192.168.1.20</NewInternalClient>
+<NewEnabled>1</NewEnabled><NewPortMappingDescription>Transmission</NewPortMappingDescription>
+<NewLeaseDuration>0</NewLeaseDuration>
+</u:GetGenericPortMappingEntryResponse></s:Body></s:Envelope>`)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>
+<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>
+<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>713</errorCode>
+<errorDescription>SpecifiedArrayIndexInvalid</errorDescription></UPnPError></detail>
+</s:Fault></s:Body></s:Envelope>`)
+	}))
+	defer server.Close()
+
+	mappings, err := NewUPnPClient(server.URL, 2).GetGenericPortMappingEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := PortMapping{
+		Description:    "Transmission",
+		Protocol:       "TCP",
+		ExternalPort:   "51413",
+		InternalClient: "192.168.1.20",
+		InternalPort:   "51413",
+		Enabled:        true,
+	}
+	if len(mappings) != 1 || mappings[0] != want {
+		t.Errorf("want [%v], have %v", want, mappings)
+	}
+}
+
+func TestParsePortMappingInfo(t *testing.T) {
+	mappings, err := parsePortMappingInfo("NAS;tcp;443;8443;192.168.1.10@Games;udp;6000-6010;6000-6010;192.168.1.20@")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PortMapping{
+		{Description: "NAS", Protocol: "TCP", ExternalPort: "443", InternalPort: "8443", InternalClient: "192.168.1.10"},
+		{Description: "Games", Protocol: "UDP", ExternalPort: "6000-6010", InternalPort: "6000-6010", InternalClient: "192.168.1.20"},
+	}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("want %v, have %v", want, mappings)
+	}
+
+	for _, info := range []string{
+		"NAS;tcp;443;192.168.1.10",
+		"NAS;tcp;443;8443;192.168.1.10;extra",
+		"NAS;tcp;https;8443;192.168.1.10",
+		"NAS;tcp;443;70000;192.168.1.10",
+		"NAS;tcp;6000-;6000;192.168.1.10",
+	} {
+		if mappings, err := parsePortMappingInfo(info); err == nil {
+			t.Errorf("%s: want an error, have %v", info, mappings)
+		}
+	}
+}
+
+func TestGetGenericPortMappingEntriesUnsupported(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusNotImplemented} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		_, err := NewUPnPClient(server.URL, 2).GetGenericPortMappingEntries()
+		server.Close()
+		if !errors.Is(err, ErrUPnPUnsupported) {
+			t.Errorf("HTTP %d: want ErrUPnPUnsupported, have %v", status, err)
+		}
+	}
+
+	/* Nothing listening, as when UPnP is turned off */
+	if _, err := NewUPnPClient("http://127.0.0.1:1/upnp", 2).GetGenericPortMappingEntries(); !errors.Is(err, ErrUPnPUnsupported) {
+		t.Errorf("connection refused: want ErrUPnPUnsupported, have %v", err)
+	}
+
+	/* Other failures are errors */
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	if _, err := NewUPnPClient(server.URL, 2).GetGenericPortMappingEntries(); err == nil || errors.Is(err, ErrUPnPUnsupported) {
+		t.Errorf("HTTP 500: want an error, have %v", err)
+	}
+}
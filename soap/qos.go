@@ -0,0 +1,18 @@
+package soap
+
+// GetQoSEnableStatus implements the DeviceConfig/GetQoSEnableStatus SOAP message
+func (c *Client) GetQoSEnableStatus() (map[string]string, error) {
+	return c.Call("DeviceConfig", "GetQoSEnableStatus")
+}
+
+// GetBandwidthControlOptions implements the AdvancedQOS/GetBandwidthControlOptions SOAP message.
+// Bandwidths are reported in Mbps.
+func (c *Client) GetBandwidthControlOptions() (map[string]string, error) {
+	return c.Call("AdvancedQOS", "GetBandwidthControlOptions")
+}
+
+// GetOOKLASpeedTestResult implements the AdvancedQOS/GetOOKLASpeedTestResult SOAP message.
+// Only firmware with the Dynamic QoS speed test keeps this result.
+func (c *Client) GetOOKLASpeedTestResult() (map[string]string, error) {
+	return c.Call("AdvancedQOS", "GetOOKLASpeedTestResult")
+}
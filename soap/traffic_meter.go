@@ -0,0 +1,12 @@
+package soap
+
+// GetTrafficMeterEnabled implements the DeviceConfig/GetTrafficMeterEnabled SOAP message
+func (c *Client) GetTrafficMeterEnabled() (map[string]string, error) {
+	return c.Call("DeviceConfig", "GetTrafficMeterEnabled")
+}
+
+// GetTrafficMeterOptions implements the DeviceConfig/GetTrafficMeterOptions SOAP message.
+// The monthly limit is in MB for volume control and in hours for connection time control.
+func (c *Client) GetTrafficMeterOptions() (map[string]string, error) {
+	return c.Call("DeviceConfig", "GetTrafficMeterOptions")
+}
@@ -0,0 +1,122 @@
+// Package webhook posts notifications about the network to HTTP endpoints.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Target is an endpoint notifications are posted to. Slack targets get a Slack
+// incoming webhook message, the others the event as JSON.
+type Target struct {
+	URL   string
+	Slack bool
+}
+
+// Event is posted as JSON to generic targets
+type Event struct {
+	Event          string    `json:"event"`
+	Message        string    `json:"message"`
+	MAC            string    `json:"mac"`
+	Name           string    `json:"name"`
+	IP             string    `json:"ip"`
+	ConnectionType string    `json:"connection_type"`
+	Time           time.Time `json:"time"`
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+const timeout = 10 * time.Second
+
+type Notifier struct {
+	targets    []Target
+	retries    int
+	backoff    time.Duration
+	httpClient *http.Client
+}
+
+// New creates a notifier posting to targets. A failed post is retried up to retries
+// times, waiting twice as long before every retry.
+func New(targets []Target, retries int) *Notifier {
+	return &Notifier{
+		targets:    targets,
+		retries:    retries,
+		backoff:    time.Second,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify posts the event to all targets in the background. done is called once every
+// target was tried, with whether any of them accepted the event, and may be nil.
+func (n *Notifier) Notify(event Event, done func(delivered bool)) {
+	go func() {
+		var wg sync.WaitGroup
+		var delivered atomic.Bool
+		for _, target := range n.targets {
+			wg.Add(1)
+			go func(target Target) {
+				defer wg.Done()
+				if err := n.post(target, event); err != nil {
+					slog.Error("failed to send webhook notification", slog.String("url", target.URL), slog.String("event", event.Event), slog.String("error", err.Error()))
+					return
+				}
+				delivered.Store(true)
+			}(target)
+		}
+		wg.Wait()
+
+		/* Without targets there is nobody to deliver to, so nothing is left to retry */
+		if done != nil {
+			done(delivered.Load() || len(n.targets) == 0)
+		}
+	}()
+}
+
+func (n *Notifier) post(target Target, event Event) error {
+	var payload interface{} = event
+	if target.Slack {
+		payload = slackMessage{Text: event.Message}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	wait := n.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.send(target.URL, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= n.retries {
+			return err
+		}
+
+		slog.Debug("retrying webhook notification", slog.String("url", target.URL), slog.Duration("wait", wait), slog.String("error", err.Error()))
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+/* Send the body once. Network errors, throttling and server errors are worth retrying */
+func (n *Notifier) send(url string, body []byte) (bool, error) {
+	resp, err := n.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
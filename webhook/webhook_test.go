@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPostRetries(t *testing.T) {
+	var calls int32
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	n := New(nil, 3)
+	n.backoff = time.Millisecond
+	if err := n.post(Target{URL: server.URL}, Event{Event: "unknown_device", MAC: "AA:BB:CC:DD:EE:FF"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if event := <-received; event.MAC != "AA:BB:CC:DD:EE:FF" {
+		t.Errorf("got %+v", event)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+}
+
+func TestPostGivesUp(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	n := New(nil, 3)
+	n.backoff = time.Millisecond
+	if err := n.post(Target{URL: server.URL}, Event{}); err == nil {
+		t.Error("expected an error")
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls for a client error, want 1", calls)
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	n := New([]Target{{URL: server.URL, Slack: true}}, 0)
+	n.Notify(Event{Event: "unknown_device", Message: "Unknown device"}, nil)
+
+	payload := <-received
+	if len(payload) != 1 || payload["text"] != "Unknown device" {
+		t.Errorf("got %v", payload)
+	}
+}
+
+func TestNotifyDone(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+
+	for _, test := range []struct {
+		name    string
+		targets []Target
+		want    bool
+	}{
+		{"one of two accepted", []Target{{URL: ok.URL}, {URL: failing.URL}}, true},
+		{"none accepted", []Target{{URL: failing.URL}}, false},
+		{"no targets", nil, true},
+	} {
+		done := make(chan bool, 1)
+		New(test.targets, 0).Notify(Event{Event: "unknown_device"}, func(delivered bool) { done <- delivered })
+		if got := <-done; got != test.want {
+			t.Errorf("%s: got delivered %v, want %v", test.name, got, test.want)
+		}
+	}
+}